@@ -0,0 +1,227 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// Driver run commands of request through device api instead of cli
+type Driver interface {
+	// Run run commands in order, output of each one is passed to f if f is not nil
+	// results of all commands are returned, commands after the failed one are skipped
+	Run(ctx context.Context, req *protocol.CliRequest, f conn.OutputFunc) ([]protocol.CmdResult, error)
+}
+
+var (
+	// DriverManagerInstance is DriverManager instance
+	DriverManagerInstance *DriverManager
+)
+
+func init() {
+	DriverManagerInstance = &DriverManager{
+		driverMap: make(map[string]Driver, 0),
+	}
+}
+
+// DriverManager manager api drivers
+type DriverManager struct {
+	driverMap map[string]Driver // driverMap mapping vendor.type.version to driver
+}
+
+// Get method return Driver instance by string
+func (s *DriverManager) Get(t string) Driver {
+	for k, v := range s.driverMap {
+		logs.Debug("[ matching ]", k, t)
+		if regexp.MustCompile(k).MatchString(t) {
+			logs.Debug("[ matched ]", k, t)
+			return v
+		}
+	}
+	return nil
+}
+
+// Register do driver registration
+func (s *DriverManager) Register(pattern string, d Driver) {
+	logs.Info("Registering api driver", pattern, d)
+	if _, ok := s.driverMap[pattern]; ok {
+		log.Fatal("pattern", pattern, "registered")
+	}
+	s.driverMap[pattern] = d
+}
+
+// RequestError api request got no answer from device, e.g. connection refused or reset
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return "api request failed, " + e.Err.Error()
+}
+
+// CommandError device rejected command
+type CommandError struct {
+	Command string
+	Code    string // device error code, if any
+	Message string
+	Retcode int // netd retcode the device error code maps to, common.ErrAPICommand if 0
+}
+
+func (e *CommandError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("command %q failed, %s", e.Command, e.Message)
+	}
+	return fmt.Sprintf("command %q failed, code %s, %s", e.Command, e.Code, e.Message)
+}
+
+// JobError job started by command, e.g. commit, failed on device
+type JobError struct {
+	ID      string
+	Result  string
+	Details string
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("job %s %s, %s", e.ID, e.Result, e.Details)
+}
+
+// NewHTTPClient return http client reaching device of req through its proxy and jump hosts, jump hosts are connected while ctx lasts
+// device certificate is verified as TLS of req says, not at all if host key policy of req is insecure
+func NewHTTPClient(ctx context.Context, req *protocol.CliRequest) (*http.Client, error) {
+	dial, policy, err := conn.DeviceDialer(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newTLSConfig(req.TLS, policy == common.HostKeyInsecure)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dial,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     time.Minute,
+		},
+		// api keys are not sent to other hosts
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}, nil
+}
+
+// newTLSConfig return config verifying device certificate as t says
+func newTLSConfig(t *protocol.TLS, insecure bool) (*tls.Config, error) {
+	if insecure {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	if t == nil {
+		return &tls.Config{}, nil
+	}
+	if t.Fingerprint != "" {
+		want, err := hex.DecodeString(strings.Replace(t.Fingerprint, ":", "", -1))
+		if err != nil || len(want) != sha256.Size {
+			return nil, fmt.Errorf("invalid tls fingerprint %q, sha256 hex expected", t.Fingerprint)
+		}
+		return &tls.Config{
+			// chain and names are not checked, the pinned certificate is trusted as is
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+				if len(raw) == 0 {
+					return fmt.Errorf("no device certificate")
+				}
+				got := sha256.Sum256(raw[0])
+				if !bytes.Equal(got[:], want) {
+					return fmt.Errorf("device certificate fingerprint %x mismatch", got)
+				}
+				return nil
+			},
+		}, nil
+	}
+	if t.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(t.CA)) {
+			return nil, fmt.Errorf("no certificate found in tls ca")
+		}
+		return &tls.Config{RootCAs: pool}, nil
+	}
+	return &tls.Config{}, nil
+}
+
+// CmdFunc run one command and return its output
+type CmdFunc func(ctx context.Context, cmd string) (string, error)
+
+// Run run commands of req in order with run, timing and status of each one are recorded like cli results
+// commands after the failed one are skipped
+func Run(ctx context.Context, req *protocol.CliRequest, f conn.OutputFunc, run CmdFunc) ([]protocol.CmdResult, error) {
+	res := make([]protocol.CmdResult, len(req.Commands))
+	var err error
+	for i, cmd := range req.Commands {
+		res[i] = protocol.CmdResult{Command: cmd, Status: protocol.CmdSkipped}
+		if err != nil {
+			continue
+		}
+		logs.Info(req.LogPrefix, "api command", cmd)
+		start := time.Now()
+		var out string
+		out, err = run(ctx, cmd)
+		res[i].Output = out
+		res[i].Duration = int64(time.Since(start) / time.Millisecond)
+		res[i].Status = CmdStatus(ctx, err)
+		if err != nil {
+			logs.Error(req.LogPrefix, "api command", cmd, "error,", err)
+			if res[i].Output == "" {
+				res[i].Output = err.Error()
+			}
+			continue
+		}
+		if f != nil {
+			f(i, cmd, out)
+		}
+	}
+	return res, err
+}
+
+// CmdStatus map error of command to result status
+func CmdStatus(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return protocol.CmdOK
+	case ctx.Err() == context.Canceled:
+		return protocol.CmdCancelled
+	case ctx.Err() == context.DeadlineExceeded:
+		return protocol.CmdTimeout
+	}
+	if _, ok := err.(*RequestError); ok {
+		return protocol.CmdLost
+	}
+	return protocol.CmdFailed
+}
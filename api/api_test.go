@@ -0,0 +1,72 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+
+	Convey("device certificate trust", t, func() {
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer ts.Close()
+		cert := ts.Certificate()
+		sum := sha256.Sum256(cert.Raw)
+		get := func(req *protocol.CliRequest) error {
+			req.Address = strings.TrimPrefix(ts.URL, "https://")
+			hc, err := NewHTTPClient(context.Background(), req)
+			if err != nil {
+				return err
+			}
+			res, err := hc.Get(ts.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			return err
+		}
+
+		Convey("self-signed certificate is refused by default", func() {
+			So(get(&protocol.CliRequest{HostKeyPolicy: common.HostKeyTOFU}), ShouldNotBeNil)
+			So(get(&protocol.CliRequest{HostKeyPolicy: common.HostKeyInsecure}), ShouldBeNil)
+		})
+
+		Convey("ca bundle", func() {
+			ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+			So(get(&protocol.CliRequest{TLS: &protocol.TLS{CA: ca}}), ShouldBeNil)
+			So(get(&protocol.CliRequest{TLS: &protocol.TLS{CA: "junk"}}), ShouldNotBeNil)
+		})
+
+		Convey("pinned fingerprint", func() {
+			fp := hex.EncodeToString(sum[:])
+			So(get(&protocol.CliRequest{TLS: &protocol.TLS{Fingerprint: fp}}), ShouldBeNil)
+			So(get(&protocol.CliRequest{TLS: &protocol.TLS{Fingerprint: strings.ToUpper(fp[:2]) + ":" + fp[2:]}}), ShouldBeNil)
+			So(get(&protocol.CliRequest{TLS: &protocol.TLS{Fingerprint: strings.Repeat("0", 64)}}), ShouldNotBeNil)
+			So(get(&protocol.CliRequest{TLS: &protocol.TLS{Fingerprint: "abc"}}), ShouldNotBeNil)
+		})
+	})
+}
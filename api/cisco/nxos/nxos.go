@@ -0,0 +1,218 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package nxos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/api"
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+func init() {
+	// register cisco nxos
+	api.DriverManagerInstance.Register(`(?i)cisco\.NX-OS\..*`, &driver{})
+}
+
+// message types of NX-API
+const (
+	cliShow      = "cli_show"       // show commands, structured json output
+	cliShowASCII = "cli_show_ascii" // show commands, text output
+	cliConf      = "cli_conf"       // configuration commands
+)
+
+// retcodes netd retcode of NX-API output code, common.ErrAPICommand for the others
+var retcodes = map[string]int{
+	"413": common.ErrAPIUnsupported, // request too large
+	"500": common.ErrAPIInternal,    // backend processing error
+	"501": common.ErrAPIUnsupported, // structured output unsupported
+}
+
+// driver NX-API ins_api over https with basic auth
+type driver struct {
+}
+
+type insAPI struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Chunk        string `json:"chunk"`
+	SID          string `json:"sid"`
+	Input        string `json:"input"`
+	OutputFormat string `json:"output_format"`
+}
+
+// output of one command
+type output struct {
+	Code     string          `json:"code"`
+	Msg      string          `json:"msg"`
+	Input    string          `json:"input"`
+	Body     json.RawMessage `json:"body"`
+	CLIError string          `json:"clierror"`
+}
+
+type response struct {
+	InsAPI struct {
+		Outputs struct {
+			Output json.RawMessage `json:"output"` // object for one command, array for more
+		} `json:"outputs"`
+	} `json:"ins_api"`
+}
+
+// msgType message type of request, cli_conf in configure_terminal mode, cli_show_ascii for text format
+func msgType(req *protocol.CliRequest) string {
+	switch {
+	case strings.EqualFold(req.Mode, "configure_terminal"):
+		return cliConf
+	case strings.EqualFold(req.Format, "text"), strings.EqualFold(req.Format, "ascii"):
+		return cliShowASCII
+	}
+	return cliShow
+}
+
+// Run send commands of request in one message, so configuration commands share context
+// duration of each result is that of the whole message, commands the device did not run are skipped
+func (d *driver) Run(ctx context.Context, req *protocol.CliRequest, f conn.OutputFunc) ([]protocol.CmdResult, error) {
+	res := make([]protocol.CmdResult, len(req.Commands))
+	for i, cmd := range req.Commands {
+		if strings.Contains(cmd, ";") {
+			return nil, &api.CommandError{Command: cmd, Message: "command contains separator ;"}
+		}
+		res[i] = protocol.CmdResult{Command: cmd, Mode: req.Mode, Status: protocol.CmdSkipped}
+	}
+	if len(req.Commands) == 0 {
+		return res, nil
+	}
+	hc, err := api.NewHTTPClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer hc.CloseIdleConnections()
+	t := msgType(req)
+	logs.Info(req.LogPrefix, "nx-api", t, req.Commands)
+	start := time.Now()
+	outputs, err := call(ctx, hc, req, t)
+	duration := int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		for i := range res {
+			res[i].Status = api.CmdStatus(ctx, err)
+		}
+		return res, err
+	}
+	var first error
+	for i := range res {
+		if i >= len(outputs) {
+			break
+		}
+		o := &outputs[i]
+		res[i].Duration = duration
+		res[i].Output = body(o, t)
+		if o.Code != "200" {
+			res[i].Status = protocol.CmdFailed
+			if o.CLIError != "" {
+				res[i].Output = strings.TrimSpace(o.CLIError)
+			}
+			if first == nil {
+				first = &api.CommandError{Command: res[i].Command, Code: o.Code, Message: strings.TrimSpace(o.Msg + ", " + o.CLIError), Retcode: retcodes[o.Code]}
+			}
+			continue
+		}
+		res[i].Status = protocol.CmdOK
+		if f != nil {
+			f(i, res[i].Command, res[i].Output)
+		}
+	}
+	return res, first
+}
+
+// body output of command, text of cli_show_ascii and json of the others
+func body(o *output, t string) string {
+	if t == cliShowASCII {
+		var s string
+		if json.Unmarshal(o.Body, &s) == nil {
+			return s
+		}
+	}
+	if len(o.Body) == 0 || string(o.Body) == "null" {
+		return ""
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, o.Body) != nil {
+		return string(o.Body)
+	}
+	return buf.String()
+}
+
+// call post commands and return outputs in order
+func call(ctx context.Context, hc *http.Client, req *protocol.CliRequest, t string) ([]output, error) {
+	b, _ := json.Marshal(map[string]*insAPI{"ins_api": {
+		Version:      "1.0",
+		Type:         t,
+		Chunk:        "0",
+		SID:          "1",
+		Input:        strings.Join(req.Commands, " ;"),
+		OutputFormat: "json",
+	}})
+	hreq, err := http.NewRequest(http.MethodPost, "https://"+req.Address+"/ins", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	hreq.SetBasicAuth(req.Auth.Username, req.Auth.Password)
+	hreq.Header.Set("Content-Type", "application/json")
+	resp, err := hc.Do(hreq.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &api.RequestError{Err: err}
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &api.RequestError{Err: err}
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, &conn.AuthError{Methods: []string{"basic"}, Err: fmt.Errorf("%s, %s", req.Address, resp.Status)}
+	}
+	var r response
+	if err := json.Unmarshal(data, &r); err != nil || len(r.InsAPI.Outputs.Output) == 0 {
+		code := strconv.Itoa(resp.StatusCode)
+		return nil, &api.CommandError{Command: req.Commands[0], Code: code, Message: resp.Status, Retcode: retcodes[code]}
+	}
+	out := r.InsAPI.Outputs.Output
+	if out[0] == '[' {
+		var outputs []output
+		if err := json.Unmarshal(out, &outputs); err != nil {
+			return nil, &api.CommandError{Command: req.Commands[0], Message: "decode response fail, " + err.Error()}
+		}
+		return outputs, nil
+	}
+	var o output
+	if err := json.Unmarshal(out, &o); err != nil {
+		return nil, &api.CommandError{Command: req.Commands[0], Message: "decode response fail, " + err.Error()}
+	}
+	return []output{o}, nil
+}
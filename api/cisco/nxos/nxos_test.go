@@ -0,0 +1,145 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package nxos
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/api"
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeNXAPI NX-API stand-in, it stops at the first failed command like cli_conf does
+type fakeNXAPI struct {
+	last insAPI
+}
+
+func (f *fakeNXAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if u, p, _ := r.BasicAuth(); u != "admin" || p != "r00tme" {
+		w.WriteHeader(401)
+		return
+	}
+	var req map[string]insAPI
+	json.NewDecoder(r.Body).Decode(&req)
+	f.last = req["ins_api"]
+	var outputs []map[string]interface{}
+	for _, cmd := range strings.Split(f.last.Input, " ;") {
+		o := map[string]interface{}{"input": cmd, "code": "200", "msg": "Success"}
+		switch {
+		case cmd == "show version" && f.last.Type == cliShowASCII:
+			o["body"] = "Cisco Nexus Operating System (NX-OS) Software\n"
+		case cmd == "show version":
+			o["body"] = map[string]string{"host_name": "n9k", "nxos_ver_str": "9.3(5)"}
+		case cmd == "show tech-support":
+			o["code"], o["msg"] = "501", "Structured output unsupported"
+		case strings.HasPrefix(cmd, "show") || f.last.Type == cliConf && strings.HasPrefix(cmd, "bogus"):
+			o["code"], o["msg"], o["clierror"] = "400", "CLI execution error", "% Invalid command at '^' marker.\n"
+		default:
+			o["body"] = map[string]interface{}{}
+		}
+		outputs = append(outputs, o)
+		if o["code"] != "200" {
+			break
+		}
+	}
+	var out interface{} = outputs
+	if len(outputs) == 1 {
+		out = outputs[0]
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ins_api": map[string]interface{}{
+		"type": f.last.Type, "version": "1.0", "sid": "eoc", "outputs": map[string]interface{}{"output": out},
+	}})
+}
+
+func TestDriver(t *testing.T) {
+
+	Convey("NX-API", t, func() {
+		fake := &fakeNXAPI{}
+		srv := httptest.NewTLSServer(fake)
+		defer srv.Close()
+		d := &driver{}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		newReq := func(cmds ...string) *protocol.CliRequest {
+			return &protocol.CliRequest{
+				Vendor:        "cisco",
+				Type:          "NX-OS",
+				Protocol:      "api",
+				Address:       srv.Listener.Addr().String(),
+				Auth:          protocol.Auth{Username: "admin", Password: "r00tme"},
+				HostKeyPolicy: common.HostKeyInsecure,
+				Commands:      cmds,
+				LogPrefix:     "[ test ]",
+			}
+		}
+
+		Convey("cli_show returns json bodies", func() {
+			res, err := d.Run(ctx, newReq("show version"), nil)
+			So(err, ShouldBeNil)
+			So(fake.last.Type, ShouldEqual, cliShow)
+			So(res[0].Status, ShouldEqual, protocol.CmdOK)
+			So(res[0].Output, ShouldEqual, `{"host_name":"n9k","nxos_ver_str":"9.3(5)"}`)
+		})
+
+		Convey("cli_show_ascii returns text", func() {
+			req := newReq("show version")
+			req.Format = "text"
+			res, err := d.Run(ctx, req, nil)
+			So(err, ShouldBeNil)
+			So(fake.last.Type, ShouldEqual, cliShowASCII)
+			So(res[0].Output, ShouldEqual, "Cisco Nexus Operating System (NX-OS) Software\n")
+		})
+
+		Convey("cli_conf in one message", func() {
+			req := newReq("interface Ethernet1/1", "bogus", "description uplink")
+			req.Mode = "configure_terminal"
+			res, err := d.Run(ctx, req, nil)
+			So(fake.last.Type, ShouldEqual, cliConf)
+			So(fake.last.Input, ShouldEqual, "interface Ethernet1/1 ;bogus ;description uplink")
+			So(err, ShouldHaveSameTypeAs, &api.CommandError{})
+			So(err.(*api.CommandError).Code, ShouldEqual, "400")
+			So(res[0].Status, ShouldEqual, protocol.CmdOK)
+			So(res[1].Status, ShouldEqual, protocol.CmdFailed)
+			So(res[1].Output, ShouldEqual, "% Invalid command at '^' marker.")
+			So(res[2].Status, ShouldEqual, protocol.CmdSkipped)
+		})
+
+		Convey("error codes map to retcodes", func() {
+			_, err := d.Run(ctx, newReq("show tech-support"), nil)
+			So(err.(*api.CommandError).Retcode, ShouldEqual, common.ErrAPIUnsupported)
+			_, err = d.Run(ctx, newReq("show bogus"), nil)
+			So(err.(*api.CommandError).Retcode, ShouldEqual, 0)
+			So(retcodes["500"], ShouldEqual, common.ErrAPIInternal)
+		})
+
+		Convey("bad credentials", func() {
+			req := newReq("show version")
+			req.Auth.Password = "wrong"
+			res, err := d.Run(ctx, req, nil)
+			So(err, ShouldHaveSameTypeAs, &conn.AuthError{})
+			So(res[0].Status, ShouldEqual, protocol.CmdFailed)
+		})
+	})
+}
@@ -0,0 +1,173 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fortigate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sky-cloud-tec/netd/api"
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/protocol"
+)
+
+func init() {
+	// register fortinet fortigate, api does not depend on model
+	api.DriverManagerInstance.Register(`(?i)fortinet\.fortigate[^.]*\..*`, &driver{})
+}
+
+// apiPrefix path prefix of FortiOS REST API
+const apiPrefix = "/api/v2/"
+
+// driver FortiOS REST API with api token
+type driver struct {
+}
+
+// command api request of one cli request command
+type command struct {
+	method string
+	path   string // path under apiPrefix, query included
+	body   []byte
+}
+
+// response of api request, cmdb and monitor endpoints share it
+type response struct {
+	Status     string          `json:"status"`
+	HTTPStatus int             `json:"http_status"`
+	Error      int             `json:"error"`
+	CLIError   string          `json:"cli_error"`
+	Results    json.RawMessage `json:"results"`
+}
+
+// Run run commands of request through REST API
+// commands are `get <path>`, `delete <path>`, `post <path> <json>` and `put <path> <json>`, paths are under /api/v2/, e.g. cmdb/firewall/address
+func (d *driver) Run(ctx context.Context, req *protocol.CliRequest, f conn.OutputFunc) ([]protocol.CmdResult, error) {
+	cmds := make(map[string]*command, len(req.Commands))
+	for _, v := range req.Commands {
+		c, err := parseCommand(v, req.Context)
+		if err != nil {
+			return nil, err
+		}
+		cmds[v] = c
+	}
+	hc, err := api.NewHTTPClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer hc.CloseIdleConnections()
+	base := "https://" + req.Address + apiPrefix
+	return api.Run(ctx, req, f, func(ctx context.Context, cmd string) (string, error) {
+		return call(ctx, hc, req, base, cmd, cmds[cmd])
+	})
+}
+
+// parseCommand map command to api request, vdom of request context is added unless path sets one
+func parseCommand(cmd, vdom string) (*command, error) {
+	fields := strings.SplitN(strings.TrimSpace(cmd), " ", 3)
+	if len(fields) < 2 {
+		return nil, &api.CommandError{Command: cmd, Message: "path missing"}
+	}
+	c := &command{method: strings.ToUpper(fields[0]), path: strings.TrimPrefix(fields[1], apiPrefix)}
+	switch c.method {
+	case http.MethodGet, http.MethodDelete:
+		if len(fields) == 3 {
+			return nil, &api.CommandError{Command: cmd, Message: "unexpected body"}
+		}
+	case http.MethodPost, http.MethodPut:
+		if len(fields) < 3 || !json.Valid([]byte(fields[2])) {
+			return nil, &api.CommandError{Command: cmd, Message: "json body missing or invalid"}
+		}
+		c.body = []byte(fields[2])
+	default:
+		return nil, &api.CommandError{Command: cmd, Message: "unknown method " + strconv.Quote(fields[0])}
+	}
+	if !strings.HasPrefix(c.path, "cmdb/") && !strings.HasPrefix(c.path, "monitor/") {
+		return nil, &api.CommandError{Command: cmd, Message: "path is neither cmdb nor monitor"}
+	}
+	u, err := url.Parse(c.path)
+	if err != nil {
+		return nil, &api.CommandError{Command: cmd, Message: err.Error()}
+	}
+	if q := u.Query(); vdom != "" && q.Get("vdom") == "" {
+		q.Set("vdom", vdom)
+		u.RawQuery = q.Encode()
+		c.path = u.String()
+	}
+	return c, nil
+}
+
+// call send api request, output is results of response, or the whole response if there is none
+func call(ctx context.Context, hc *http.Client, req *protocol.CliRequest, base, cmd string, c *command) (string, error) {
+	var body io.Reader
+	if c.body != nil {
+		body = bytes.NewReader(c.body)
+	}
+	hreq, err := http.NewRequest(c.method, base+c.path, body)
+	if err != nil {
+		return "", &api.CommandError{Command: cmd, Message: err.Error()}
+	}
+	hreq.Header.Set("Authorization", "Bearer "+req.Auth.Password)
+	hreq.Header.Set("Accept", "application/json")
+	if c.body != nil {
+		hreq.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := hc.Do(hreq.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", &api.RequestError{Err: err}
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", &api.RequestError{Err: err}
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", &conn.AuthError{Methods: []string{"token"}, Err: fmt.Errorf("%s, %s", req.Address, resp.Status)}
+	}
+	var r response
+	if err := json.Unmarshal(b, &r); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return "", &api.CommandError{Command: cmd, Code: strconv.Itoa(resp.StatusCode), Message: resp.Status}
+		}
+		return "", &api.CommandError{Command: cmd, Message: "decode response fail, " + err.Error()}
+	}
+	out := strings.TrimSpace(string(b))
+	if len(r.Results) > 0 {
+		out = string(r.Results)
+	}
+	if resp.StatusCode != http.StatusOK || r.Status == "error" {
+		e := &api.CommandError{Command: cmd, Code: strconv.Itoa(resp.StatusCode), Message: resp.Status}
+		if r.Error != 0 {
+			e.Code = strconv.Itoa(r.Error)
+			e.Message = fmt.Sprintf("%s, error %d", resp.Status, r.Error)
+		}
+		if r.CLIError != "" {
+			e.Message += ", " + strings.TrimSpace(r.CLIError)
+		}
+		return out, e
+	}
+	return out, nil
+}
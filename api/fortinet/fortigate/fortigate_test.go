@@ -0,0 +1,179 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fortigate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/api"
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeFortiGate FortiOS REST API stand-in, firewall addresses per vdom
+type fakeFortiGate struct {
+	mu        sync.Mutex
+	addresses map[string]map[string]json.RawMessage // vdom to name to address
+}
+
+func (f *fakeFortiGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply := func(code int, v map[string]interface{}) {
+		v["http_status"] = code
+		if code == 200 {
+			v["status"] = "success"
+		} else {
+			v["status"] = "error"
+		}
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(v)
+	}
+	if r.Header.Get("Authorization") != "Bearer t0ken" {
+		w.WriteHeader(401)
+		return
+	}
+	vdom := r.URL.Query().Get("vdom")
+	if vdom == "" {
+		vdom = "root"
+	}
+	if f.addresses[vdom] == nil {
+		f.addresses[vdom] = make(map[string]json.RawMessage)
+	}
+	addrs := f.addresses[vdom]
+	path := strings.TrimPrefix(r.URL.Path, "/api/v2/")
+	switch {
+	case path == "monitor/system/status":
+		reply(200, map[string]interface{}{"results": map[string]string{"hostname": "fgt1"}, "vdom": vdom})
+	case path == "cmdb/firewall/address" && r.Method == http.MethodGet:
+		list := []json.RawMessage{}
+		for _, v := range addrs {
+			list = append(list, v)
+		}
+		reply(200, map[string]interface{}{"results": list, "vdom": vdom})
+	case path == "cmdb/firewall/address" && r.Method == http.MethodPost:
+		b, _ := ioutil.ReadAll(r.Body)
+		var a struct {
+			Name string `json:"name"`
+		}
+		json.Unmarshal(b, &a)
+		if _, ok := addrs[a.Name]; ok {
+			reply(500, map[string]interface{}{"error": -5, "cli_error": "entry already exists"})
+			return
+		}
+		addrs[a.Name] = b
+		reply(200, map[string]interface{}{"mkey": a.Name, "vdom": vdom})
+	case strings.HasPrefix(path, "cmdb/firewall/address/") && r.Method == http.MethodDelete:
+		name := strings.TrimPrefix(path, "cmdb/firewall/address/")
+		if _, ok := addrs[name]; !ok {
+			reply(404, map[string]interface{}{"error": -3})
+			return
+		}
+		delete(addrs, name)
+		reply(200, map[string]interface{}{"mkey": name, "vdom": vdom})
+	default:
+		reply(404, map[string]interface{}{})
+	}
+}
+
+func TestDriver(t *testing.T) {
+
+	Convey("FortiOS REST API", t, func() {
+		fgt := &fakeFortiGate{addresses: make(map[string]map[string]json.RawMessage)}
+		srv := httptest.NewTLSServer(fgt)
+		defer srv.Close()
+		d := &driver{}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		newReq := func(vdom string, cmds ...string) *protocol.CliRequest {
+			return &protocol.CliRequest{
+				Vendor:        "fortinet",
+				Type:          "FortiGate-VM64-KVM",
+				Protocol:      "api",
+				Address:       srv.Listener.Addr().String(),
+				Auth:          protocol.Auth{Password: "t0ken"},
+				HostKeyPolicy: common.HostKeyInsecure,
+				Context:       vdom,
+				Commands:      cmds,
+				LogPrefix:     "[ test ]",
+			}
+		}
+
+		Convey("cmdb and monitor per vdom", func() {
+			res, err := d.Run(ctx, newReq("vdom1",
+				`post cmdb/firewall/address {"name":"a1","subnet":"10.0.0.1 255.255.255.255"}`,
+				"get /api/v2/cmdb/firewall/address",
+				"get monitor/system/status",
+			), nil)
+			So(err, ShouldBeNil)
+			for _, r := range res {
+				So(r.Status, ShouldEqual, protocol.CmdOK)
+			}
+			So(res[0].Output, ShouldContainSubstring, `"mkey":"a1"`)
+			So(res[1].Output, ShouldEqual, `[{"name":"a1","subnet":"10.0.0.1 255.255.255.255"}]`)
+			So(res[2].Output, ShouldEqual, `{"hostname":"fgt1"}`)
+			So(len(fgt.addresses["vdom1"]), ShouldEqual, 1)
+
+			// other vdom does not see it
+			res, err = d.Run(ctx, newReq("", "get cmdb/firewall/address"), nil)
+			So(err, ShouldBeNil)
+			So(res[0].Output, ShouldEqual, `[]`)
+			// vdom of path wins
+			res, err = d.Run(ctx, newReq("root", "delete cmdb/firewall/address/a1?vdom=vdom1"), nil)
+			So(err, ShouldBeNil)
+			So(len(fgt.addresses["vdom1"]), ShouldEqual, 0)
+		})
+
+		Convey("device error", func() {
+			cmd := `post cmdb/firewall/address {"name":"a1"}`
+			res, err := d.Run(ctx, newReq("", cmd, cmd, "get cmdb/firewall/address"), nil)
+			So(err, ShouldHaveSameTypeAs, &api.CommandError{})
+			So(err.(*api.CommandError).Code, ShouldEqual, "-5")
+			So(err.Error(), ShouldContainSubstring, "entry already exists")
+			So(res[0].Status, ShouldEqual, protocol.CmdOK)
+			So(res[1].Status, ShouldEqual, protocol.CmdFailed)
+			So(res[2].Status, ShouldEqual, protocol.CmdSkipped)
+		})
+
+		Convey("bad token", func() {
+			req := newReq("", "get monitor/system/status")
+			req.Auth.Password = "wrong"
+			_, err := d.Run(ctx, req, nil)
+			So(err, ShouldHaveSameTypeAs, &conn.AuthError{})
+		})
+
+		Convey("invalid commands", func() {
+			for _, cmd := range []string{"get", "patch cmdb/firewall/address", "post cmdb/firewall/address {", "get system/status", "delete cmdb/firewall/address x"} {
+				_, err := d.Run(ctx, newReq("", cmd), nil)
+				So(err, ShouldHaveSameTypeAs, &api.CommandError{})
+			}
+			c, err := parseCommand("get cmdb/firewall/address?filter=name==a1", "vdom1")
+			So(err, ShouldBeNil)
+			So(c.path, ShouldEqual, fmt.Sprintf("cmdb/firewall/address?%s", "filter=name%3D%3Da1&vdom=vdom1"))
+		})
+	})
+}
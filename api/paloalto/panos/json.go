@@ -0,0 +1,106 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package panos
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+)
+
+// node xml element
+type node struct {
+	name     string
+	attrs    []xml.Attr
+	children []*node
+	text     strings.Builder
+}
+
+// listElements always converted to arrays, they are list items in PAN-OS schema
+var listElements = map[string]bool{"entry": true, "member": true}
+
+// value convert element to json value
+// attributes are @name keys, text beside children or attributes is #text, repeated children are arrays
+func (n *node) value() interface{} {
+	text := strings.TrimSpace(n.text.String())
+	if len(n.attrs) == 0 && len(n.children) == 0 {
+		return text
+	}
+	m := make(map[string]interface{})
+	for _, a := range n.attrs {
+		m["@"+a.Name.Local] = a.Value
+	}
+	for _, c := range n.children {
+		v := c.value()
+		prev, ok := m[c.name]
+		switch {
+		case !ok && listElements[c.name]:
+			m[c.name] = []interface{}{v}
+		case !ok:
+			m[c.name] = v
+		default:
+			if list, isList := prev.([]interface{}); isList {
+				m[c.name] = append(list, v)
+			} else {
+				m[c.name] = []interface{}{prev, v}
+			}
+		}
+	}
+	if text != "" {
+		m["#text"] = text
+	}
+	return m
+}
+
+// xmlToJSON convert content of result element to json
+func xmlToJSON(content string) (string, error) {
+	d := xml.NewDecoder(strings.NewReader("<result>" + content + "</result>"))
+	var (
+		root  *node
+		stack []*node
+	)
+	for {
+		t, err := d.Token()
+		if err != nil {
+			if root != nil && len(stack) == 0 {
+				break
+			}
+			return "", err
+		}
+		switch v := t.(type) {
+		case xml.StartElement:
+			n := &node{name: v.Name.Local, attrs: v.Attr}
+			if len(stack) > 0 {
+				p := stack[len(stack)-1]
+				p.children = append(p.children, n)
+			} else {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(v)
+			}
+		}
+	}
+	b, err := json.Marshal(root.value())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
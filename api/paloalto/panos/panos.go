@@ -0,0 +1,427 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package panos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/api"
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+func init() {
+	// register paloalto
+	api.DriverManagerInstance.Register(`(?i)paloalto\.pan-os\..*`, newDriver())
+}
+
+// jobPollInterval interval of job status queries after commit
+var jobPollInterval = 2 * time.Second
+
+// codeUnauthorized response code of invalid credentials or api key
+const codeUnauthorized = "403"
+
+// driver PAN-OS XML API, api keys are cached by session key of request
+type driver struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func newDriver() *driver {
+	return &driver{keys: make(map[string]string)}
+}
+
+// command api request of one cli request command
+type command struct {
+	params url.Values
+	commit bool // poll commit job till it finishes
+}
+
+// client api client of one request
+type client struct {
+	d      *driver
+	req    *protocol.CliRequest
+	http   *http.Client
+	url    string
+	key    string
+	keyRef string // cache key of api key
+}
+
+// Run run commands of request through XML API
+// commands are `op <xml or words>`, `get|show|delete <xpath>`, `set|edit <xpath> <element>` and `commit [xml]`
+func (d *driver) Run(ctx context.Context, req *protocol.CliRequest, f conn.OutputFunc) ([]protocol.CmdResult, error) {
+	cmds := make(map[string]*command, len(req.Commands))
+	for _, v := range req.Commands {
+		c, err := parseCommand(v)
+		if err != nil {
+			return nil, err
+		}
+		cmds[v] = c
+	}
+	hc, err := api.NewHTTPClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer hc.CloseIdleConnections()
+	c := &client{d: d, req: req, http: hc, url: "https://" + req.Address + "/api/", keyRef: conn.SessionKey(req)}
+	return api.Run(ctx, req, f, func(ctx context.Context, cmd string) (string, error) {
+		return c.run(ctx, cmd, cmds[cmd])
+	})
+}
+
+// parseCommand map command to api parameters
+func parseCommand(cmd string) (*command, error) {
+	fields := strings.SplitN(strings.TrimSpace(cmd), " ", 2)
+	verb, rest := fields[0], ""
+	if len(fields) == 2 {
+		rest = strings.TrimSpace(fields[1])
+	}
+	params := url.Values{}
+	switch verb {
+	case "op":
+		if rest == "" {
+			return nil, &api.CommandError{Command: cmd, Message: "op command missing"}
+		}
+		if !strings.HasPrefix(rest, "<") {
+			x, err := opXML(rest)
+			if err != nil {
+				return nil, &api.CommandError{Command: cmd, Message: err.Error()}
+			}
+			rest = x
+		}
+		params.Set("type", "op")
+		params.Set("cmd", rest)
+	case "get", "show", "delete":
+		if rest == "" {
+			return nil, &api.CommandError{Command: cmd, Message: "xpath missing"}
+		}
+		params.Set("type", "config")
+		params.Set("action", verb)
+		params.Set("xpath", rest)
+	case "set", "edit":
+		m := elementRe.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, &api.CommandError{Command: cmd, Message: "xpath or element missing"}
+		}
+		params.Set("type", "config")
+		params.Set("action", verb)
+		params.Set("xpath", m[1])
+		params.Set("element", m[2])
+	case "commit":
+		if rest == "" {
+			rest = "<commit></commit>"
+		}
+		params.Set("type", "commit")
+		params.Set("cmd", rest)
+		return &command{params: params, commit: true}, nil
+	default:
+		return nil, &api.CommandError{Command: cmd, Message: "unknown action " + strconv.Quote(verb)}
+	}
+	return &command{params: params}, nil
+}
+
+// elementRe xpath followed by xml element
+var elementRe = regexp.MustCompile(`(?s)^(\S.*?)\s+(<.*)$`)
+
+// keywordRe op command keyword, used as xml element name
+var keywordRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// opXML convert op command words to xml, quoted words are values of the word before them
+// e.g. show interface "ethernet1/1" is <show><interface>ethernet1/1</interface></show>
+func opXML(s string) (string, error) {
+	var (
+		tokens []string
+		quoted []bool
+	)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated quote in %q", s)
+			}
+			tokens, quoted = append(tokens, s[1:end+1]), append(quoted, true)
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+		tokens, quoted = append(tokens, s[:end]), append(quoted, false)
+		s = s[end:]
+	}
+	var (
+		buf   bytes.Buffer
+		stack []string
+	)
+	for i := 0; i < len(tokens); i++ {
+		if quoted[i] {
+			return "", fmt.Errorf("value %q without keyword", tokens[i])
+		}
+		if !keywordRe.MatchString(tokens[i]) {
+			return "", fmt.Errorf("invalid keyword %q", tokens[i])
+		}
+		if i+1 < len(tokens) && quoted[i+1] {
+			buf.WriteString("<" + tokens[i] + ">")
+			xml.EscapeText(&buf, []byte(tokens[i+1]))
+			buf.WriteString("</" + tokens[i] + ">")
+			i++
+			continue
+		}
+		buf.WriteString("<" + tokens[i] + ">")
+		stack = append(stack, tokens[i])
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		buf.WriteString("</" + stack[i] + ">")
+	}
+	return buf.String(), nil
+}
+
+// response of api request
+type response struct {
+	XMLName xml.Name `xml:"response"`
+	Status  string   `xml:"status,attr"`
+	Code    string   `xml:"code,attr"`
+	Result  *struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"result"`
+	body []byte
+}
+
+// message text of msg elements of response, lines are joined
+func (r *response) message() string {
+	d := xml.NewDecoder(bytes.NewReader(r.body))
+	var (
+		lines []string
+		depth int
+	)
+	for {
+		t, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch v := t.(type) {
+		case xml.StartElement:
+			if v.Name.Local == "msg" || depth > 0 {
+				depth++
+			}
+		case xml.EndElement:
+			if depth > 0 {
+				depth--
+			}
+		case xml.CharData:
+			if s := strings.TrimSpace(string(v)); depth > 0 && s != "" {
+				lines = append(lines, s)
+			}
+		}
+	}
+	return strings.Join(lines, "; ")
+}
+
+// call send api request, response of status other than success is returned along with the error
+func (c *client) call(ctx context.Context, cmd string, params url.Values) (*response, error) {
+	form := url.Values{}
+	for k, v := range params {
+		form[k] = v
+	}
+	if params.Get("type") != "keygen" {
+		form.Set("key", c.key)
+		if c.req.Context != "" {
+			form.Set("vsys", c.req.Context)
+		}
+	}
+	hreq, err := http.NewRequest(http.MethodPost, c.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.http.Do(hreq.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &api.RequestError{Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &api.RequestError{Err: err}
+	}
+	r := &response{body: body}
+	if err := xml.Unmarshal(body, r); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, &api.CommandError{Command: cmd, Code: strconv.Itoa(resp.StatusCode), Message: resp.Status}
+		}
+		return nil, &api.CommandError{Command: cmd, Message: "decode response fail, " + err.Error()}
+	}
+	if r.Status != "success" {
+		if r.Code == "" && resp.StatusCode != http.StatusOK {
+			r.Code = strconv.Itoa(resp.StatusCode)
+		}
+		return r, &api.CommandError{Command: cmd, Code: r.Code, Message: r.message()}
+	}
+	return r, nil
+}
+
+// login use api key of request, cached one or generate one
+func (c *client) login(ctx context.Context) error {
+	if strings.EqualFold(c.req.Auth.Method, common.AuthAPIKey) {
+		c.key = c.req.Auth.Password
+		return nil
+	}
+	c.d.mu.Lock()
+	c.key = c.d.keys[c.keyRef]
+	c.d.mu.Unlock()
+	if c.key != "" {
+		return nil
+	}
+	params := url.Values{"type": {"keygen"}, "user": {c.req.Auth.Username}, "password": {c.req.Auth.Password}}
+	r, err := c.call(ctx, "keygen", params)
+	if err != nil {
+		if e, ok := err.(*api.CommandError); ok {
+			return &conn.AuthError{Methods: []string{"keygen"}, Err: fmt.Errorf("%s, %s", c.req.Address, e.Message)}
+		}
+		return err
+	}
+	var k struct {
+		Key string `xml:"result>key"`
+	}
+	xml.Unmarshal(r.body, &k)
+	if k.Key == "" {
+		return &conn.AuthError{Methods: []string{"keygen"}, Err: fmt.Errorf("%s, no key in response", c.req.Address)}
+	}
+	logs.Info(c.req.LogPrefix, "api key generated")
+	c.key = k.Key
+	c.d.mu.Lock()
+	c.d.keys[c.keyRef] = k.Key
+	c.d.mu.Unlock()
+	return nil
+}
+
+// do call api with key, generated key is renewed once if device rejects it
+func (c *client) do(ctx context.Context, cmd string, params url.Values) (*response, error) {
+	if c.key == "" {
+		if err := c.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+	r, err := c.call(ctx, cmd, params)
+	if e, ok := err.(*api.CommandError); ok && e.Code == codeUnauthorized {
+		if strings.EqualFold(c.req.Auth.Method, common.AuthAPIKey) {
+			return r, &conn.AuthError{Methods: []string{common.AuthAPIKey}, Err: fmt.Errorf("%s, %s", c.req.Address, e.Message)}
+		}
+		logs.Info(c.req.LogPrefix, "api key rejected, generating new one")
+		c.d.mu.Lock()
+		delete(c.d.keys, c.keyRef)
+		c.d.mu.Unlock()
+		if err := c.login(ctx); err != nil {
+			return nil, err
+		}
+		r, err = c.call(ctx, cmd, params)
+	}
+	return r, err
+}
+
+func (c *client) run(ctx context.Context, cmd string, p *command) (string, error) {
+	r, err := c.do(ctx, cmd, p.params)
+	if err != nil {
+		if r != nil {
+			return c.output(r), err
+		}
+		return "", err
+	}
+	if p.commit {
+		var job struct {
+			ID string `xml:"result>job"`
+		}
+		xml.Unmarshal(r.body, &job)
+		// nothing to commit if no job enqueued
+		if job.ID != "" {
+			return c.wait(ctx, cmd, job.ID)
+		}
+	}
+	return c.output(r), nil
+}
+
+// wait poll job till it finishes, output is the job status
+func (c *client) wait(ctx context.Context, cmd, id string) (string, error) {
+	params := url.Values{"type": {"op"}, "cmd": {"<show><jobs><id>" + id + "</id></jobs></show>"}}
+	for {
+		r, err := c.do(ctx, cmd, params)
+		if err != nil {
+			return "", err
+		}
+		var job struct {
+			Status   string   `xml:"result>job>status"`
+			Result   string   `xml:"result>job>result"`
+			Progress string   `xml:"result>job>progress"`
+			Details  []string `xml:"result>job>details>line"`
+		}
+		xml.Unmarshal(r.body, &job)
+		if job.Status == "FIN" {
+			if job.Result != "OK" {
+				return c.output(r), &api.JobError{ID: id, Result: job.Result, Details: strings.Join(job.Details, "; ")}
+			}
+			return c.output(r), nil
+		}
+		logs.Info(c.req.LogPrefix, "job", id, job.Status, job.Progress+"%")
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// output content of result element, or message if there is none
+// json format of request converts it to json
+func (c *client) output(r *response) string {
+	asJSON := strings.EqualFold(c.req.Format, "json")
+	if r.Result == nil {
+		if asJSON {
+			return jsonString(r.message())
+		}
+		return r.message()
+	}
+	if asJSON {
+		out, err := xmlToJSON(r.Result.Inner)
+		if err != nil {
+			logs.Error(c.req.LogPrefix, "convert result to json fail,", err)
+			return strings.TrimSpace(r.Result.Inner)
+		}
+		return out
+	}
+	return strings.TrimSpace(r.Result.Inner)
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
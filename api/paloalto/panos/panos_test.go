@@ -0,0 +1,247 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package panos
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/api"
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeFirewall PAN-OS XML API stand-in
+type fakeFirewall struct {
+	mu       sync.Mutex
+	keygens  int
+	keys     map[string]bool
+	polls    int    // job queries
+	config   string // element set under rulebase
+	commitOK bool
+}
+
+func (f *fakeFirewall) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r.ParseForm()
+	reply := func(code int, body string) {
+		w.WriteHeader(code)
+		fmt.Fprint(w, body)
+	}
+	if r.Form.Get("type") == "keygen" {
+		if r.Form.Get("user") != "admin" || r.Form.Get("password") != "r00tme" {
+			reply(403, `<response status="error" code="403"><result><msg>Invalid Credential</msg></result></response>`)
+			return
+		}
+		f.keygens++
+		key := fmt.Sprintf("KEY%d", f.keygens)
+		f.keys[key] = true
+		reply(200, `<response status="success"><result><key>`+key+`</key></result></response>`)
+		return
+	}
+	if !f.keys[r.Form.Get("key")] {
+		reply(403, `<response status="error" code="403"><result><msg>Invalid credential</msg></result></response>`)
+		return
+	}
+	switch r.Form.Get("type") + " " + r.Form.Get("action") {
+	case "op ":
+		switch cmd := r.Form.Get("cmd"); {
+		case cmd == "<show><system><info></info></system></show>":
+			reply(200, `<response status="success"><result><system><hostname>fw1</hostname><sw-version>10.1.0</sw-version></system></result></response>`)
+		case strings.HasPrefix(cmd, "<show><jobs><id>7</id>"):
+			f.polls++
+			status, result := "ACT", "PEND"
+			if f.polls > 1 {
+				status, result = "FIN", "FAIL"
+				if f.commitOK {
+					result = "OK"
+				}
+			}
+			reply(200, `<response status="success"><result><job><id>7</id><type>Commit</type><status>`+status+`</status><result>`+result+
+				`</result><progress>50</progress><details><line>rule r1 invalid</line></details></job></result></response>`)
+		default:
+			reply(200, `<response status="error" code="17"><msg><line><![CDATA[ show -> bad is unexpected]]></line></msg></response>`)
+		}
+	case "config set":
+		if !strings.HasPrefix(r.Form.Get("xpath"), "/config/") {
+			reply(200, `<response status="error" code="12"><msg><line>Invalid xpath</line></msg></response>`)
+			return
+		}
+		f.config = r.Form.Get("element")
+		reply(200, `<response status="success" code="20"><msg>command succeeded</msg></response>`)
+	case "config get":
+		reply(200, `<response status="success" code="19"><result total-count="1" count="1"><rules><entry name="r1">`+f.config+`</entry></rules></result></response>`)
+	case "commit ":
+		reply(200, `<response status="success" code="19"><result><msg><line>Commit job enqueued with jobid 7</line></msg><job>7</job></result></response>`)
+	default:
+		reply(400, "bad request")
+	}
+}
+
+func TestOpXML(t *testing.T) {
+
+	Convey("op command words to xml", t, func() {
+		x, err := opXML("show system info")
+		So(err, ShouldBeNil)
+		So(x, ShouldEqual, "<show><system><info></info></system></show>")
+		x, err = opXML(`show interface "ethernet1/1"`)
+		So(err, ShouldBeNil)
+		So(x, ShouldEqual, "<show><interface>ethernet1/1</interface></show>")
+		x, err = opXML(`test security-policy-match from "trust" to "un trust"`)
+		So(err, ShouldBeNil)
+		So(x, ShouldEqual, "<test><security-policy-match><from>trust</from><to>un trust</to></security-policy-match></test>")
+		_, err = opXML(`show "x`)
+		So(err, ShouldNotBeNil)
+		_, err = opXML(`"x" show`)
+		So(err, ShouldNotBeNil)
+		for _, bad := range []string{`show <system>`, `show sys/info`, `show a"b`, `show x></show><y`} {
+			_, err = opXML(bad)
+			So(err, ShouldNotBeNil)
+		}
+		x, err = opXML(`show config "<a>&</a>"`)
+		So(err, ShouldBeNil)
+		So(x, ShouldEqual, "<show><config>&lt;a&gt;&amp;&lt;/a&gt;</config></show>")
+	})
+
+	Convey("commands", t, func() {
+		c, err := parseCommand(`set /config/devices/entry/vsys/entry[@name='vsys1']/address <entry name="a1"><ip-netmask>10.0.0.1/32</ip-netmask></entry>`)
+		So(err, ShouldBeNil)
+		So(c.params.Get("xpath"), ShouldEqual, "/config/devices/entry/vsys/entry[@name='vsys1']/address")
+		So(c.params.Get("element"), ShouldStartWith, `<entry name="a1">`)
+		_, err = parseCommand("set /config/devices")
+		So(err, ShouldNotBeNil)
+		_, err = parseCommand("reboot")
+		So(err, ShouldHaveSameTypeAs, &api.CommandError{})
+		c, err = parseCommand("commit")
+		So(err, ShouldBeNil)
+		So(c.commit, ShouldBeTrue)
+	})
+
+	Convey("result to json", t, func() {
+		out, err := xmlToJSON(`<rules><entry name="r1"><from><member>trust</member></from><action>allow</action></entry></rules>`)
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, `{"rules":{"entry":[{"@name":"r1","action":"allow","from":{"member":["trust"]}}]}}`)
+	})
+}
+
+func TestDriver(t *testing.T) {
+
+	Convey("PAN-OS XML API", t, func() {
+		fw := &fakeFirewall{keys: make(map[string]bool), commitOK: true}
+		srv := httptest.NewTLSServer(fw)
+		defer srv.Close()
+		saved := jobPollInterval
+		jobPollInterval = 10 * time.Millisecond
+		defer func() { jobPollInterval = saved }()
+		d := newDriver()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		newReq := func(cmds ...string) *protocol.CliRequest {
+			return &protocol.CliRequest{
+				Vendor:        "paloalto",
+				Type:          "pan-os",
+				Protocol:      "api",
+				Address:       srv.Listener.Addr().String(),
+				Auth:          protocol.Auth{Username: "admin", Password: "r00tme"},
+				HostKeyPolicy: common.HostKeyInsecure,
+				Commands:      cmds,
+				LogPrefix:     "[ test ]",
+			}
+		}
+
+		Convey("op, config and commit", func() {
+			req := newReq(
+				"op show system info",
+				`set /config/devices/entry/vsys/entry/rulebase/security/rules <entry name="r1"><action>allow</action></entry>`,
+				"get /config/devices/entry/vsys/entry/rulebase/security/rules",
+				"commit",
+			)
+			res, err := d.Run(ctx, req, nil)
+			So(err, ShouldBeNil)
+			So(len(res), ShouldEqual, 4)
+			for _, r := range res {
+				So(r.Status, ShouldEqual, protocol.CmdOK)
+			}
+			So(res[0].Output, ShouldEqual, "<system><hostname>fw1</hostname><sw-version>10.1.0</sw-version></system>")
+			So(res[1].Output, ShouldEqual, "command succeeded")
+			So(res[2].Output, ShouldContainSubstring, "<action>allow</action>")
+			So(res[3].Output, ShouldContainSubstring, "<result>OK</result>")
+			So(fw.polls, ShouldEqual, 2)
+
+			// key is cached
+			req.Format = "json"
+			req.Commands = []string{"op show system info"}
+			res, err = d.Run(ctx, req, nil)
+			So(err, ShouldBeNil)
+			So(res[0].Output, ShouldEqual, `{"system":{"hostname":"fw1","sw-version":"10.1.0"}}`)
+			So(fw.keygens, ShouldEqual, 1)
+		})
+
+		Convey("rejected key is generated again", func() {
+			_, err := d.Run(ctx, newReq("op show system info"), nil)
+			So(err, ShouldBeNil)
+			fw.mu.Lock()
+			fw.keys = make(map[string]bool)
+			fw.mu.Unlock()
+			res, err := d.Run(ctx, newReq("op show system info"), nil)
+			So(err, ShouldBeNil)
+			So(res[0].Status, ShouldEqual, protocol.CmdOK)
+			So(fw.keygens, ShouldEqual, 2)
+		})
+
+		Convey("failed command skips the rest", func() {
+			res, err := d.Run(ctx, newReq("set /bad <entry/>", "commit"), nil)
+			So(err, ShouldHaveSameTypeAs, &api.CommandError{})
+			So(err.(*api.CommandError).Code, ShouldEqual, "12")
+			So(res[0].Status, ShouldEqual, protocol.CmdFailed)
+			So(res[0].Output, ShouldEqual, "Invalid xpath")
+			So(res[1].Status, ShouldEqual, protocol.CmdSkipped)
+		})
+
+		Convey("failed commit job", func() {
+			fw.commitOK = false
+			res, err := d.Run(ctx, newReq("commit"), nil)
+			So(err, ShouldHaveSameTypeAs, &api.JobError{})
+			So(err.Error(), ShouldContainSubstring, "rule r1 invalid")
+			So(res[0].Status, ShouldEqual, protocol.CmdFailed)
+		})
+
+		Convey("bad credentials", func() {
+			req := newReq("op show system info")
+			req.Auth.Password = "wrong"
+			res, err := d.Run(ctx, req, nil)
+			So(err, ShouldHaveSameTypeAs, &conn.AuthError{})
+			So(res[0].Status, ShouldEqual, protocol.CmdFailed)
+		})
+
+		Convey("certificate is verified unless policy is insecure", func() {
+			req := newReq("op show system info")
+			req.HostKeyPolicy = common.HostKeyStrict
+			res, err := d.Run(ctx, req, nil)
+			So(err, ShouldHaveSameTypeAs, &api.RequestError{})
+			So(res[0].Status, ShouldEqual, protocol.CmdLost)
+		})
+	})
+}
@@ -16,6 +16,7 @@
 package asa
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
@@ -35,6 +36,10 @@ type op9xPlus struct {
 	transitions map[string][]string
 	prompts     map[string][]*regexp.Regexp
 	errs        []*regexp.Regexp
+	pagers      []cli.Pager
+	confirms    []cli.Confirm
+	hints       cli.OutputHints
+	closePage   []string // commands disabling pager
 }
 
 func createOp9xPlus() cli.Operator {
@@ -53,11 +58,23 @@ func createOp9xPlus() cli.Operator {
 			"login":                 {loginPrompt},
 			"login_enable":          {loginEnablePrompt},
 			"configure_terminal":    {configTerminalPrompt},
+			// telnet login
+			cli.PromptUsername:       {regexp.MustCompile("^Username: ?$")},
+			cli.PromptPassword:       {regexp.MustCompile("^Password: ?$")},
+			cli.PromptEnablePassword: {regexp.MustCompile("^Password: ?$")},
 		},
 		errs: []*regexp.Regexp{
 			regexp.MustCompile("^ERROR: "),
 		},
-		lineBeak: "\n",
+		pagers: []cli.Pager{
+			{Pattern: regexp.MustCompile(`<--- More --->`), Continue: " "},
+		},
+		confirms: []cli.Confirm{
+			{Pattern: regexp.MustCompile(`\[confirm\] ?$`), Answer: ""},
+			{Pattern: regexp.MustCompile(`\[[^\]]*\]\? ?$`), Answer: ""},
+		},
+		closePage: []string{"terminal pager 0", "terminal pager lines 0"},
+		lineBeak:  "\n",
 	}
 }
 
@@ -83,10 +100,76 @@ func (s *op9xPlus) GetLinebreak() string {
 	return s.lineBeak
 }
 
+func (s *op9xPlus) GetModes() []string {
+	return []string{"login", "login_enable", "configure_terminal"}
+}
+
+func (s *op9xPlus) GetKeepaliveCommand() string {
+	// empty line
+	return ""
+}
+
+func (s *op9xPlus) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+func (s *op9xPlus) GetPagers() []cli.Pager {
+	return s.pagers
+}
+
+func (s *op9xPlus) GetClosePageCommands() []string {
+	return s.closePage
+}
+
+func (s *op9xPlus) GetConfirms() []cli.Confirm {
+	return s.confirms
+}
+
+func (s *op9xPlus) GetOutputHints() cli.OutputHints {
+	return s.hints
+}
+
+func (s *op9xPlus) Escalate(ctx context.Context, session cli.Session) error {
+	if session.Mode() != "login_or_login_enable" {
+		return nil
+	}
+	if !cli.Match(s.GetPrompts("login"), session.Prompt()) {
+		session.SetMode("login_enable")
+		return nil
+	}
+	session.SetMode("login")
+	if session.Request().Mode == "login" {
+		return nil
+	}
+	// enter privileged mode
+	return cli.Enable(ctx, s, session)
+}
+
+func (s *op9xPlus) AfterLogin(ctx context.Context, session cli.Session) error {
+	if session.Mode() == "login" {
+		// not privileged
+		return nil
+	}
+	return cli.ClosePage(ctx, s, session, cli.ModeGroup(s, session))
+}
+
+func (s *op9xPlus) Setup(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *op9xPlus) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
 func (s *op9xPlus) GetStartMode() string {
 	return "login_or_login_enable"
 }
 
+func (s *op9xPlus) GetReadOnlyModes() []string {
+	// user exec, show commands only
+	return []string{"login"}
+}
+
 func (s *op9xPlus) GetSSHInitializer() cli.SSHInitializer {
 	return func(c *ssh.Client, req *protocol.CliRequest) (io.Reader, io.WriteCloser, *ssh.Session, error) {
 		var err error
@@ -16,12 +16,48 @@
 package asa
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
 	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/protocol"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+// fakeSession answer sent commands with prompts of a script
+type fakeSession struct {
+	req    *protocol.CliRequest
+	mode   string
+	prompt string
+	sent   []string
+	script map[string]string // cmd -> prompt shown after it
+}
+
+func (s *fakeSession) Request() *protocol.CliRequest { return s.req }
+func (s *fakeSession) Mode() string                  { return s.mode }
+func (s *fakeSession) SetMode(m string)              { s.mode = m }
+func (s *fakeSession) Prompt() string                { return s.prompt }
+
+func (s *fakeSession) Send(cmd string) error {
+	s.sent = append(s.sent, cmd)
+	s.prompt = s.script[cmd]
+	return nil
+}
+
+func (s *fakeSession) Read(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func (s *fakeSession) Expect(ctx context.Context, groups ...cli.PromptGroup) (string, string, error) {
+	for _, g := range groups {
+		if cli.Match(g.Patterns, s.prompt) {
+			return "", g.Name, nil
+		}
+	}
+	return "", "", fmt.Errorf("unexpected prompt %q", s.prompt)
+}
+
 func TestAsaOp(t *testing.T) {
 
 	Convey("asa op", t, func() {
@@ -31,4 +67,40 @@ func TestAsaOp(t *testing.T) {
 			ShouldBeTrue,
 		)
 	})
+
+	Convey("asa session setup", t, func() {
+		op := createOp9xPlus()
+		session := &fakeSession{
+			req:    &protocol.CliRequest{Mode: "configure_terminal", EnablePwd: "s3cret"},
+			mode:   op.GetStartMode(),
+			prompt: "asaNAT> ",
+			script: map[string]string{
+				"enable":                 "Password: ",
+				"s3cret":                 "asaNAT# ",
+				"terminal pager 0":       "asaNAT# ",
+				"terminal pager lines 0": "asaNAT# ",
+			},
+		}
+
+		Convey("escalated from login prompt", func() {
+			So(op.Escalate(context.Background(), session), ShouldBeNil)
+			So(session.Mode(), ShouldEqual, "login_enable")
+			So(op.AfterLogin(context.Background(), session), ShouldBeNil)
+			So(session.sent, ShouldResemble, []string{"enable", "s3cret", "terminal pager 0", "terminal pager lines 0"})
+		})
+
+		Convey("stay in login mode", func() {
+			session.req.Mode = "login"
+			So(op.Escalate(context.Background(), session), ShouldBeNil)
+			So(op.AfterLogin(context.Background(), session), ShouldBeNil)
+			So(session.Mode(), ShouldEqual, "login")
+			So(session.sent, ShouldBeEmpty)
+		})
+
+		Convey("already privileged", func() {
+			session.prompt = "asaNAT# "
+			So(op.Escalate(context.Background(), session), ShouldBeNil)
+			So(session.Mode(), ShouldEqual, "login_enable")
+		})
+	})
 }
@@ -16,6 +16,7 @@
 package ios
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
@@ -30,12 +31,16 @@ func init() {
 	cli.OperatorManagerInstance.Register(`(?i)cisco\.ios\..*`, createSwitchIos())
 }
 
-//SwitchIos struct
+// SwitchIos struct
 type SwitchIos struct {
 	lineBeak    string // \r\n \n
 	transitions map[string][]string
 	prompts     map[string][]*regexp.Regexp
 	errs        []*regexp.Regexp
+	pagers      []cli.Pager
+	confirms    []cli.Confirm
+	hints       cli.OutputHints
+	closePage   []string // commands disabling pager
 }
 
 func createSwitchIos() cli.Operator {
@@ -60,11 +65,19 @@ func createSwitchIos() cli.Operator {
 			regexp.MustCompile("^% "),
 			regexp.MustCompile("^Command rejected:"),
 		},
-		lineBeak: "\n",
+		pagers: []cli.Pager{
+			{Pattern: regexp.MustCompile(`--More-- ?$`), Continue: " "},
+		},
+		confirms: []cli.Confirm{
+			{Pattern: regexp.MustCompile(`\[confirm\] ?$`), Answer: ""},
+			{Pattern: regexp.MustCompile(`\[[^\]]*\]\? ?$`), Answer: ""},
+		},
+		closePage: []string{"terminal length 0"},
+		lineBeak:  "\n",
 	}
 }
 
-//GetPrompts SwitchIos
+// GetPrompts SwitchIos
 func (s *SwitchIos) GetPrompts(k string) []*regexp.Regexp {
 	if v, ok := s.prompts[k]; ok {
 		return v
@@ -72,7 +85,7 @@ func (s *SwitchIos) GetPrompts(k string) []*regexp.Regexp {
 	return nil
 }
 
-//GetTransitions SwitchIos
+// GetTransitions SwitchIos
 func (s *SwitchIos) GetTransitions(c, t string) []string {
 	k := c + "->" + t
 	if v, ok := s.transitions[k]; ok {
@@ -81,22 +94,100 @@ func (s *SwitchIos) GetTransitions(c, t string) []string {
 	return nil
 }
 
-//GetErrPatterns SwitchIos
+// GetErrPatterns SwitchIos
 func (s *SwitchIos) GetErrPatterns() []*regexp.Regexp {
 	return s.errs
 }
 
-//GetLinebreak SwitchIos
+// GetLinebreak SwitchIos
 func (s *SwitchIos) GetLinebreak() string {
 	return s.lineBeak
 }
 
-//GetStartMode SwitchIos
+// GetModes SwitchIos
+func (s *SwitchIos) GetModes() []string {
+	return []string{"login", "login_enable", "configure_terminal"}
+}
+
+// GetKeepaliveCommand SwitchIos
+func (s *SwitchIos) GetKeepaliveCommand() string {
+	// empty line
+	return ""
+}
+
+// GetInterrupt SwitchIos
+func (s *SwitchIos) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+// GetPagers SwitchIos
+func (s *SwitchIos) GetPagers() []cli.Pager {
+	return s.pagers
+}
+
+// GetClosePageCommands SwitchIos
+func (s *SwitchIos) GetClosePageCommands() []string {
+	return s.closePage
+}
+
+// GetConfirms SwitchIos
+func (s *SwitchIos) GetConfirms() []cli.Confirm {
+	return s.confirms
+}
+
+// GetOutputHints SwitchIos
+func (s *SwitchIos) GetOutputHints() cli.OutputHints {
+	return s.hints
+}
+
+// Escalate SwitchIos
+func (s *SwitchIos) Escalate(ctx context.Context, session cli.Session) error {
+	if session.Mode() != "login_or_login_enable" {
+		return nil
+	}
+	if !cli.Match(s.GetPrompts("login"), session.Prompt()) {
+		session.SetMode("login_enable")
+		return nil
+	}
+	session.SetMode("login")
+	if session.Request().Mode == "login" {
+		return nil
+	}
+	// enter privileged mode
+	return cli.Enable(ctx, s, session)
+}
+
+// AfterLogin SwitchIos
+func (s *SwitchIos) AfterLogin(ctx context.Context, session cli.Session) error {
+	if session.Mode() == "login" {
+		// not privileged
+		return nil
+	}
+	return cli.ClosePage(ctx, s, session, cli.ModeGroup(s, session))
+}
+
+// Setup SwitchIos
+func (s *SwitchIos) Setup(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+// BeforeClose SwitchIos
+func (s *SwitchIos) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+// GetStartMode SwitchIos
 func (s *SwitchIos) GetStartMode() string {
 	return "login_or_login_enable"
 }
 
-//GetSSHInitializer SwitchIos
+// GetReadOnlyModes SwitchIos
+func (s *SwitchIos) GetReadOnlyModes() []string {
+	// user exec, show commands only
+	return []string{"login"}
+}
+
+// GetSSHInitializer SwitchIos
 func (s *SwitchIos) GetSSHInitializer() cli.SSHInitializer {
 	return func(c *ssh.Client, req *protocol.CliRequest) (io.Reader, io.WriteCloser, *ssh.Session, error) {
 		var err error
@@ -16,6 +16,7 @@
 package nxos
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
@@ -30,12 +31,16 @@ func init() {
 	cli.OperatorManagerInstance.Register(`(?i)cisco\.NX-OS\..*`, createSwitchNxos())
 }
 
-//SwitchNxos struct
+// SwitchNxos struct
 type SwitchNxos struct {
 	lineBeak    string // \r\n \n
 	transitions map[string][]string
 	prompts     map[string][]*regexp.Regexp
 	errs        []*regexp.Regexp
+	pagers      []cli.Pager
+	confirms    []cli.Confirm
+	hints       cli.OutputHints
+	closePage   []string // commands disabling pager
 }
 
 func createSwitchNxos() cli.Operator {
@@ -57,11 +62,18 @@ func createSwitchNxos() cli.Operator {
 			regexp.MustCompile("^% "),
 			regexp.MustCompile("^% Invalid command at '\\^' marker\\."),
 		},
-		lineBeak: "\n",
+		pagers: []cli.Pager{
+			{Pattern: regexp.MustCompile(`--More-- ?$`), Continue: " "},
+		},
+		confirms: []cli.Confirm{
+			{Pattern: regexp.MustCompile(`\(y/n\)\?? ?\[[yn]\] ?$`), Answer: "y"},
+		},
+		closePage: []string{"terminal length 0"},
+		lineBeak:  "\n",
 	}
 }
 
-//GetPrompts SwitchNxos
+// GetPrompts SwitchNxos
 func (s *SwitchNxos) GetPrompts(k string) []*regexp.Regexp {
 	if v, ok := s.prompts[k]; ok {
 		return v
@@ -69,7 +81,7 @@ func (s *SwitchNxos) GetPrompts(k string) []*regexp.Regexp {
 	return nil
 }
 
-//GetTransitions SwitchNxos
+// GetTransitions SwitchNxos
 func (s *SwitchNxos) GetTransitions(c, t string) []string {
 	k := c + "->" + t
 	if v, ok := s.transitions[k]; ok {
@@ -78,22 +90,78 @@ func (s *SwitchNxos) GetTransitions(c, t string) []string {
 	return nil
 }
 
-//GetErrPatterns SwitchNxos
+// GetErrPatterns SwitchNxos
 func (s *SwitchNxos) GetErrPatterns() []*regexp.Regexp {
 	return s.errs
 }
 
-//GetLinebreak SwitchNxos
+// GetLinebreak SwitchNxos
 func (s *SwitchNxos) GetLinebreak() string {
 	return s.lineBeak
 }
 
-//GetStartMode SwitchNxos
+// GetModes SwitchNxos
+func (s *SwitchNxos) GetModes() []string {
+	return []string{"login", "configure_terminal"}
+}
+
+// GetKeepaliveCommand SwitchNxos
+func (s *SwitchNxos) GetKeepaliveCommand() string {
+	// empty line
+	return ""
+}
+
+// GetInterrupt SwitchNxos
+func (s *SwitchNxos) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+// GetPagers SwitchNxos
+func (s *SwitchNxos) GetPagers() []cli.Pager {
+	return s.pagers
+}
+
+// GetClosePageCommands SwitchNxos
+func (s *SwitchNxos) GetClosePageCommands() []string {
+	return s.closePage
+}
+
+// GetConfirms SwitchNxos
+func (s *SwitchNxos) GetConfirms() []cli.Confirm {
+	return s.confirms
+}
+
+// GetOutputHints SwitchNxos
+func (s *SwitchNxos) GetOutputHints() cli.OutputHints {
+	return s.hints
+}
+
+// Escalate SwitchNxos
+func (s *SwitchNxos) Escalate(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+// AfterLogin SwitchNxos
+func (s *SwitchNxos) AfterLogin(ctx context.Context, session cli.Session) error {
+	return cli.ClosePage(ctx, s, session, cli.ModeGroup(s, session))
+}
+
+// Setup SwitchNxos
+func (s *SwitchNxos) Setup(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+// BeforeClose SwitchNxos
+func (s *SwitchNxos) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+// GetStartMode SwitchNxos
 func (s *SwitchNxos) GetStartMode() string {
 	return "login"
 }
 
-//GetSSHInitializer SwitchNxos
+// GetSSHInitializer SwitchNxos
 func (s *SwitchNxos) GetSSHInitializer() cli.SSHInitializer {
 	return func(c *ssh.Client, req *protocol.CliRequest) (io.Reader, io.WriteCloser, *ssh.Session, error) {
 		var err error
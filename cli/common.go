@@ -27,3 +27,17 @@ func Match(patterns []*regexp.Regexp, s string) bool {
 	}
 	return false
 }
+
+var defaultLoginPrompts = map[string][]*regexp.Regexp{
+	PromptUsername:       {regexp.MustCompile(`(?i)(username|login|user name): ?$`)},
+	PromptPassword:       {regexp.MustCompile(`(?i)password: ?$`)},
+	PromptEnablePassword: {regexp.MustCompile(`(?i)password: ?$`)},
+}
+
+// GetLoginPrompts return login prompts of key declared by operator, fallback to common ones
+func GetLoginPrompts(op Operator, k string) []*regexp.Regexp {
+	if v := op.GetPrompts(k); v != nil {
+		return v
+	}
+	return defaultLoginPrompts[k]
+}
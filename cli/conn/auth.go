@@ -0,0 +1,163 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AuthError device rejected all offered auth methods
+type AuthError struct {
+	Methods []string // methods offered to device
+	Err     error    // error returned by ssh handshake
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("auth failed with methods [%s], %s", strings.Join(e.Methods, " "), e.Err)
+}
+
+// isAuthErr return true if ssh handshake failed at authentication stage
+func isAuthErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "unable to authenticate")
+}
+
+// sshAuth ssh auth methods built from request auth
+type sshAuth struct {
+	methods []ssh.AuthMethod
+	names   []string
+	agent   net.Conn // ssh-agent socket, closed after handshake
+}
+
+func (s *sshAuth) add(name string, m ssh.AuthMethod) {
+	s.methods = append(s.methods, m)
+	s.names = append(s.names, name)
+}
+
+// Close release ssh-agent socket
+func (s *sshAuth) Close() error {
+	if s.agent == nil {
+		return nil
+	}
+	return s.agent.Close()
+}
+
+// newSSHAuth build auth methods, if auth.Method is empty every method the auth contains material for is offered
+func newSSHAuth(auth *protocol.Auth) (*sshAuth, error) {
+	a := &sshAuth{}
+	want := func(m string) bool {
+		return auth.Method == "" || strings.EqualFold(auth.Method, m)
+	}
+	// certificate first, device may accept the bare key otherwise
+	if auth.PrivateKey != "" && (want(common.AuthCertificate) || want(common.AuthPublicKey)) {
+		signer, err := parsePrivateKey(auth)
+		if err != nil {
+			return nil, err
+		}
+		if auth.Certificate != "" && want(common.AuthCertificate) {
+			certSigner, err := newCertSigner(auth.Certificate, signer)
+			if err != nil {
+				return nil, err
+			}
+			a.add(common.AuthCertificate, ssh.PublicKeys(certSigner))
+		}
+		if want(common.AuthPublicKey) {
+			a.add(common.AuthPublicKey, ssh.PublicKeys(signer))
+		}
+	}
+	if (auth.Agent && auth.Method == "") || strings.EqualFold(auth.Method, common.AuthAgent) {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, fmt.Errorf("ssh-agent auth requested but SSH_AUTH_SOCK not set")
+		}
+		c, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, fmt.Errorf("connect ssh-agent failed, %s", err)
+		}
+		a.agent = c
+		a.add(common.AuthAgent, ssh.PublicKeysCallback(agent.NewClient(c).Signers))
+	}
+	if auth.Password != "" {
+		if want(common.AuthPassword) {
+			a.add(common.AuthPassword, ssh.Password(auth.Password))
+		}
+		if want(common.AuthKeyboardInteractive) {
+			a.add(common.AuthKeyboardInteractive, ssh.KeyboardInteractive(keyboardInteractive(auth)))
+		}
+	}
+	if len(a.methods) == 0 {
+		a.Close()
+		if auth.Method != "" {
+			return nil, fmt.Errorf("auth method %s not support or missing credentials", auth.Method)
+		}
+		return nil, fmt.Errorf("no credentials provided")
+	}
+	return a, nil
+}
+
+func parsePrivateKey(auth *protocol.Auth) (ssh.Signer, error) {
+	var (
+		signer ssh.Signer
+		err    error
+	)
+	if auth.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(auth.PrivateKey), []byte(auth.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(auth.PrivateKey))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse private key failed, %s", err)
+	}
+	return signer, nil
+}
+
+func newCertSigner(certificate string, signer ssh.Signer) (ssh.Signer, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate failed, %s", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("parse certificate failed, %s is not a certificate", pub.Type())
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate signer failed, %s", err)
+	}
+	return certSigner, nil
+}
+
+// keyboardInteractive answer hidden prompts with password and echoed prompts with username
+func keyboardInteractive(auth *protocol.Auth) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			if echos[i] {
+				answers[i] = auth.Username
+			} else {
+				answers[i] = auth.Password
+			}
+		}
+		return answers, nil
+	}
+}
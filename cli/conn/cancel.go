@@ -0,0 +1,117 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+const (
+	interruptTimeout = 5 * time.Second        // wait for prompt after interrupt sent
+	drainQuiet       = 300 * time.Millisecond // output after prompt is drained till device stays quiet this long
+)
+
+// timeoutError no prompt read within timeout
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("read stdout timeout after %q", e.timeout)
+}
+
+// chunk output read from session
+type chunk struct {
+	data []byte
+	err  error
+}
+
+// startReader start goroutine reading session output into chunks
+// one reader lives as long as the transport, so reads abandoned by cancellation leak nothing
+func (s *CliConn) startReader() {
+	var r io.Reader = s.conn
+	if s.t == common.SSHConn {
+		r = s.r
+	}
+	chunks, done := make(chan chunk), make(chan struct{})
+	s.chunks, s.done = chunks, done
+	go func() {
+		for {
+			buf := make([]byte, 1000)
+			n, err := r.Read(buf) //this reads the ssh/telnet terminal
+			select {
+			case chunks <- chunk{buf[:n], err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// stopReader let reader goroutine go, it exits once transport is closed
+func (s *CliConn) stopReader() {
+	if s.done != nil {
+		close(s.done)
+	}
+	s.chunks, s.done = nil, nil
+}
+
+// interrupt abort command left running by cancellation or timeout and resync to a mode prompt
+func (s *CliConn) interrupt() error {
+	logs.Notice(s.req.LogPrefix, "interrupting command in mode", s.mode)
+	if _, err := s.write([]byte(s.op.GetInterrupt())); err != nil {
+		return err
+	}
+	groups := []promptGroup{{s.mode, s.op.GetPrompts(s.mode)}}
+	for _, m := range s.op.GetModes() {
+		if m != s.mode {
+			groups = append(groups, promptGroup{m, s.op.GetPrompts(m)})
+		}
+	}
+	_, _, matched, err := s.expectWithin(context.Background(), interruptTimeout, groups...)
+	if err != nil {
+		return fmt.Errorf("no prompt after interrupt, %s", err)
+	}
+	s.mode = matched
+	s.drain()
+	logs.Notice(s.req.LogPrefix, "resynced in mode", s.mode)
+	return nil
+}
+
+// drain discard output till device stays quiet, so prompts echoed late do not end the next read
+func (s *CliConn) drain() {
+	for {
+		select {
+		case c := <-s.chunks:
+			if c.err != nil {
+				s.stopReader()
+				return
+			}
+			logs.Debug(s.req.LogPrefix, "drained", string(c.data))
+		case <-time.After(drainQuiet):
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCancel(t *testing.T) {
+
+	Convey("interrupt running command", t, func() {
+		dev := newFakeDevice(func(cmd string) (string, bool) { return "output of " + cmd + "\r\n", false })
+		defer dev.Close()
+		op := cli.OperatorManagerInstance.Get("cisco.asa.9.6")
+		m := NewManager()
+		req := &protocol.CliRequest{
+			Address:   dev.addr(),
+			Protocol:  "telnet",
+			Auth:      protocol.Auth{Username: "admin", Password: "r00tme"},
+			Mode:      "login",
+			Commands:  []string{"hang"},
+			Timeout:   5 * time.Second,
+			LogPrefix: "[ test ]",
+		}
+		next := func() {
+			next := *req
+			next.Commands = []string{"show version"}
+			c, err := m.Acquire(context.Background(), &next, op)
+			So(err, ShouldBeNil)
+			out, err := c.Exec(context.Background())
+			m.Release(c)
+			So(err, ShouldBeNil)
+			So(out["show version"], ShouldContainSubstring, "output of show version")
+			So(m.Stats()[0].Created, ShouldEqual, 1)
+		}
+
+		Convey("on cancellation", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			c, err := m.Acquire(ctx, req, op)
+			So(err, ShouldBeNil)
+			_, err = c.Exec(ctx)
+			m.Release(c)
+			So(err == context.DeadlineExceeded, ShouldBeTrue)
+			So(m.Stats()[0].Idle, ShouldEqual, 1)
+			next()
+		})
+
+		Convey("on read timeout", func() {
+			req.Timeout = 300 * time.Millisecond
+			c, err := m.Acquire(context.Background(), req, op)
+			So(err, ShouldBeNil)
+			_, err = c.Exec(context.Background())
+			m.Release(c)
+			So(err, ShouldHaveSameTypeAs, &timeoutError{})
+			So(m.Stats()[0].Idle, ShouldEqual, 1)
+			req.Timeout = 5 * time.Second
+			next()
+		})
+
+		Convey("waiting for a session", func() {
+			c, err := m.Acquire(context.Background(), req, op)
+			So(err, ShouldBeNil)
+			defer m.Release(c)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err = m.Acquire(ctx, req, op)
+			So(err == context.DeadlineExceeded, ShouldBeTrue)
+		})
+	})
+}
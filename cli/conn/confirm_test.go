@@ -0,0 +1,77 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfirm(t *testing.T) {
+
+	Convey("confirmation prompts answered", t, func() {
+		dev := newFakeDevice(func(cmd string) (string, bool) { return "output of " + cmd + "\r\n", false })
+		defer dev.Close()
+		op := cli.OperatorManagerInstance.Get("cisco.asa.9.6")
+		m := NewManager()
+		defer m.CloseAll()
+		req := &protocol.CliRequest{
+			Address:   dev.addr(),
+			Protocol:  "telnet",
+			Auth:      protocol.Auth{Username: "admin", Password: "r00tme"},
+			Mode:      "login",
+			Commands:  []string{"reload", "copy run flash:", "show version"},
+			Timeout:   2 * time.Second,
+			LogPrefix: "[ test ]",
+		}
+		run := func() (map[string]string, error) {
+			c, err := m.Acquire(context.Background(), req, op)
+			So(err, ShouldBeNil)
+			defer m.Release(c)
+			return c.Exec(context.Background())
+		}
+
+		Convey("with operator defaults", func() {
+			out, err := run()
+			So(err, ShouldBeNil)
+			So(out["reload"], ShouldContainSubstring, "answered \n")
+			So(out["copy run flash:"], ShouldContainSubstring, "answered \n")
+			So(out["show version"], ShouldContainSubstring, "output of show version")
+		})
+
+		Convey("with request answers", func() {
+			req.Answers = []protocol.Answer{
+				{Command: "copy run flash:", Expect: `Destination filename \[.*\]\? $`, Send: "backup.cfg"},
+				{Expect: `\[confirm\]$`, Send: "y"},
+			}
+			out, err := run()
+			So(err, ShouldBeNil)
+			So(out["reload"], ShouldContainSubstring, "answered y\n")
+			So(out["copy run flash:"], ShouldContainSubstring, "answered backup.cfg\n")
+		})
+
+		Convey("with invalid answer", func() {
+			req.Answers = []protocol.Answer{{Expect: `[`, Send: "y"}}
+			_, err := run()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -17,8 +17,10 @@ package conn
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"regexp"
 	"strings"
 	"time"
@@ -29,251 +31,172 @@ import (
 
 	"github.com/sky-cloud-tec/netd/common"
 	"golang.org/x/crypto/ssh"
-
-	"github.com/ziutek/telnet"
-)
-
-var (
-	conns map[string]*CliConn
-	semas map[string]chan struct{}
 )
 
-func init() {
-	conns = make(map[string]*CliConn, 0)
-	semas = make(map[string]chan struct{}, 0)
-}
-
 // CliConn cli connection
 type CliConn struct {
-	t    int                  // connection type 0 = ssh, 1 = telnet
-	mode string               // device cli mode
-	req  *protocol.CliRequest // cli request
-	op   cli.Operator         // cli operator
+	t      int                  // connection type 0 = ssh, 1 = telnet, 2 = console
+	mode   string               // device cli mode
+	prompt string               // last prompt read
+	req    *protocol.CliRequest // cli request
+	op     cli.Operator         // cli operator
 
-	conn   *telnet.Conn // telnet connection
-	client *ssh.Client  // ssh client
+	conn   net.Conn      // telnet or console connection
+	client *ssh.Client   // ssh client
+	shared *sharedClient // ssh client shared with other sessions, if any
 
 	session *ssh.Session   // ssh session
 	r       io.Reader      // ssh session stdout
 	w       io.WriteCloser // ssh session stdin
+
+	chunks chan chunk    // output read by reader goroutine
+	done   chan struct{} // closed to stop reader goroutine
+
+	output  OutputFunc    // receives command output while it is read, if set
+	sink    func(string)  // output receiver of command being read
+	answers []cli.Confirm // request answers of command being read, tried before operator ones
+
+	pool      *pool     // pool the session belongs to
+	gen       int       // pool generation the session is created in
+	exclusive bool      // exclusive sema of pool held
+	closed    bool      // dropped from pool, guarded by pool mutex
+	opened    time.Time // session opened at
+	lastUsed  time.Time // last request released at
 }
 
-// Acquire cli conn
-func Acquire(req *protocol.CliRequest, op cli.Operator) (*CliConn, error) {
-	// limit concurrency to 1
-	// there only one req for one connection always
-	logs.Info(req.LogPrefix, "Acquiring sema...")
-	if semas[req.Address] == nil {
-		semas[req.Address] = make(chan struct{}, 1)
-	}
-	// try
-	semas[req.Address] <- struct{}{}
-	logs.Info(req.LogPrefix, "sema acquired")
-	if req.Mode == "" {
-		req.Mode = op.GetStartMode()
-	}
-	// if cli conn already created
-	if v, ok := conns[req.Address]; ok {
-		v.req = req
-		v.op = op
-		logs.Info(req.LogPrefix, "cli conn exist")
-		return v, nil
-	}
-	c, err := newCliConn(req, op)
+func newCliConn(ctx context.Context, req *protocol.CliRequest, op cli.Operator) (*CliConn, error) {
+	logs.Info(req.LogPrefix, "creating cli conn...")
+	policy, err := hostKeyPolicy(req)
 	if err != nil {
 		return nil, err
 	}
-	conns[req.Address] = c
-	return c, nil
-}
-
-// Release cli conn
-func Release(req *protocol.CliRequest) {
-	if len(semas[req.Address]) > 0 {
-		logs.Info(req.LogPrefix, "Releasing sema")
-		<-semas[req.Address]
+	dial, err := deviceDialer(ctx, req, policy)
+	if err != nil {
+		return nil, err
 	}
-	logs.Info(req.LogPrefix, "sema released")
-}
-
-func newCliConn(req *protocol.CliRequest, op cli.Operator) (*CliConn, error) {
-	logs.Info(req.LogPrefix, "creating cli conn...")
 	if strings.ToLower(req.Protocol) == "ssh" {
-		sshConfig := &ssh.ClientConfig{
-			User:            req.Auth.Username,
-			Auth:            []ssh.AuthMethod{ssh.Password(req.Auth.Password)},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			Timeout:         5 * time.Second,
-		}
-		sshConfig.SetDefaults()
-		sshConfig.Ciphers = append(sshConfig.Ciphers, []string{"aes128-cbc", "3des-cbc"}...)
-		client, err := ssh.Dial("tcp", req.Address, sshConfig)
+		client, err := dialSSH(ctx, dial, req.Address, &req.Auth, policy)
 		if err != nil {
 			logs.Error(req.LogPrefix, "dial", req.Address, "error", err)
-			return nil, fmt.Errorf("%s dial %s error, %s", req.LogPrefix, req.Address, err)
+			return nil, err
 		}
 		c := &CliConn{t: common.SSHConn, client: client, req: req, op: op, mode: op.GetStartMode()}
-		if err := c.init(); err != nil {
+		if err := c.init(ctx); err != nil {
 			c.Close()
 			return nil, err
 		}
 		return c, nil
 	} else if strings.ToLower(req.Protocol) == "telnet" {
-		conn, err := telnet.DialTimeout("tcp", req.Address, 5*time.Second)
+		nc, err := dial(ctx, "tcp", req.Address)
 		if err != nil {
 			return nil, fmt.Errorf("[ %s ] dial %s error, %s", req.Device, req.Address, err)
 		}
+		conn := newTelnetConn(nc, common.TerminalType, common.TerminalWidth, common.TerminalHeight)
 		c := &CliConn{t: common.TELNETConn, conn: conn, req: req, op: op, mode: op.GetStartMode()}
+		if err := c.init(ctx); err != nil {
+			c.Close()
+			return nil, err
+		}
 		return c, nil
+	} else if strings.ToLower(req.Protocol) == "console" {
+		return newConsoleConn(ctx, req, op, dial, policy)
 	}
 	return nil, fmt.Errorf("protocol %s not support", req.Protocol)
 }
 
-func (s *CliConn) heartbeat() {
-	go func() {
-		tick := time.Tick(30 * time.Second)
-		for {
-			select {
-			case <-tick:
-				// try
-				logs.Info(s.req.LogPrefix, "Acquiring heartbeat sema...")
-				semas[s.req.Address] <- struct{}{}
-				logs.Info(s.req.LogPrefix, "heartbeat sema acquired")
-				if _, err := s.writeBuff(""); err != nil {
-					semas[s.req.Address] <- struct{}{}
-					logs.Critical(s.req.LogPrefix, "heartbeat error,", err)
-					s.Close()
-					return
-				}
-				if _, _, err := s.readBuff(); err != nil {
-					semas[s.req.Address] <- struct{}{}
-					logs.Critical(s.req.LogPrefix, "heartbeat error,", err)
-					s.Close()
-					return
-				}
-				<-semas[s.req.Address]
-			}
-		}
-	}()
+func (s *CliConn) init(ctx context.Context) error {
+	if _, err := s.open(ctx); err != nil {
+		return err
+	}
+	if s.mode != s.op.GetStartMode() {
+		// console line left in another mode, session setup commands may not apply there
+		logs.Notice(s.req.LogPrefix, "sitting in mode", s.mode, ", skip session setup")
+		return nil
+	}
+	if err := s.op.Escalate(ctx, s); err != nil {
+		return err
+	}
+	return s.op.AfterLogin(ctx, s)
 }
 
-func (s *CliConn) init() error {
-	if s.t == common.SSHConn {
+// open start shell, return the first prompt
+func (s *CliConn) open(ctx context.Context) (string, error) {
+	switch s.t {
+	case common.SSHConn:
 		f := s.op.GetSSHInitializer()
 		var err error
 		s.r, s.w, s.session, err = f(s.client, s.req)
 		if err != nil {
-			return err
+			return "", err
 		}
 		// read login prompt
-		_, prompt, err := s.readBuff()
+		_, prompt, err := s.readBuff(ctx)
 		if err != nil {
-			return fmt.Errorf("read after login failed, %s", err)
-		}
-		// enable cases
-		if s.mode == "login_or_login_enable" {
-			// check prompt
-			loginPrompts := s.op.GetPrompts("login")
-			if cli.Match(loginPrompts, prompt) {
-				s.mode = "login"
-				if s.mode != s.req.Mode {
-					// login is not the target mode, need transition
-					// enter privileged mode
-					if _, err := s.writeBuff("enable" + s.op.GetLinebreak() + s.req.EnablePwd); err != nil {
-						return fmt.Errorf("enter privileged mode err, %s", err)
-					}
-					s.mode = "login_enable"
-					if _, _, err := s.readBuff(); err != nil {
-						s.mode = "login"
-						return fmt.Errorf("readBuff after enable err, %s", err)
-					}
-					if err := s.closePage(); err != nil {
-						return err
-					}
-				}
-			}
-		} else {
-			if strings.EqualFold(s.req.Vendor, "Paloalto") && strings.EqualFold(s.req.Type, "PAN-OS") {
-				// set format
-				if s.req.Format != "" {
-					if _, err := s.writeBuff("set cli config-output-format " + s.req.Format); err != nil {
-						return err
-					}
-				}
-				// close page
-				if err := s.closePage(); err != nil {
-                                	return err
-                        	}
-			} else if strings.EqualFold(s.req.Vendor, "fortinet") && strings.EqualFold(s.req.Type, "fortigate-VM64-KVM") {
-				if pts := s.op.GetPrompts(s.req.Mode); pts != nil {
-					//no vdom
-					if !strings.Contains(pts[0].String(), s.req.Mode) {
-						return s.closePage()
-					}
-					logs.Debug(s.req.LogPrefix, "entering domain global...")
-					if _, err := s.writeBuff("config global"); err != nil {
-						return err
-					}
-					if err := s.closePage(); err != nil {
-						return err
-					}
-					logs.Debug(s.req.LogPrefix, "exiting vdom global ...") 
-					if _, err := s.writeBuff("end"); err != nil {
-						return err
-					}
-					if _, _, err := s.readBuff(); err != nil {
-						return err;
-					}
-				}
-			} else {
-				if err := s.closePage(); err != nil {
-                                	return err
-                        	}		
-			}
+			return "", fmt.Errorf("read after login failed, %s", err)
 		}
+		return prompt, nil
+	case common.CONSOLEConn:
+		return s.wake(ctx)
 	}
-	s.heartbeat()
-	return nil
+	return s.login(ctx, "", "")
 }
 
-func (s *CliConn) closePage() error {
-	if strings.EqualFold(s.req.Vendor, "cisco") && (strings.EqualFold(s.req.Type, "asa") || strings.EqualFold(s.req.Type, "asav")) {
-		// ===config or normal both ok===
-		// set terminal pager
-		if _, err := s.writeBuff("terminal pager 0"); err != nil {
-			return err
-		}
-		// set page lines
-		if _, err := s.writeBuff("terminal pager lines 0"); err != nil {
-			return err
-		}
-	} else if strings.EqualFold(s.req.Vendor, "cisco") && strings.EqualFold(s.req.Type, "ios") {
-		if _, err := s.writeBuff("terminal length 0"); err != nil {
-			return err
+// login answer telnet username and password prompts until start mode prompt shows up
+// matched and prompt are the login prompt already read, if any
+func (s *CliConn) login(ctx context.Context, matched, prompt string) (string, error) {
+	groups := []promptGroup{
+		{cli.PromptUsername, cli.GetLoginPrompts(s.op, cli.PromptUsername)},
+		{cli.PromptPassword, cli.GetLoginPrompts(s.op, cli.PromptPassword)},
+		{s.mode, s.op.GetPrompts(s.mode)},
+	}
+	answered := make(map[string]bool, 2)
+	for {
+		if matched == "" {
+			var err error
+			if _, prompt, matched, err = s.expect(ctx, groups...); err != nil {
+				return "", fmt.Errorf("telnet login failed, %s", err)
+			}
 		}
-	} else if strings.EqualFold(s.req.Vendor, "Paloalto") && strings.EqualFold(s.req.Type, "PAN-OS") {
-		// set pager
-		if _, err := s.writeBuff("set cli pager off"); err != nil {
-			return err
+		var answer string
+		switch matched {
+		case cli.PromptUsername:
+			answer = s.req.Auth.Username
+		case cli.PromptPassword:
+			answer = s.req.Auth.Password
+		default:
+			logs.Info(s.req.LogPrefix, "telnet login succeeded")
+			return prompt, nil
 		}
-	} else if strings.EqualFold(s.req.Vendor, "hillstone") && strings.EqualFold(s.req.Type, "SG-6000-VM01") {
-		// set pager
-		if _, err := s.writeBuff("terminal length 0"); err != nil {
-			return err
+		// asked again, credentials rejected
+		if answered[matched] || answered[cli.PromptPassword] {
+			return "", &AuthError{Methods: []string{"telnet"}, Err: fmt.Errorf("prompt %q after credentials sent", prompt)}
 		}
-	} else if strings.EqualFold(s.req.Vendor, "fortinet") && strings.EqualFold(s.req.Type, "fortigate-VM64-KVM") {
-		// set console
-		if _, err := s.writeBuff("config system console\n\tset output standard\nend"); err != nil {
-			return err
+		logs.Info(s.req.LogPrefix, "answering", matched, "prompt")
+		answered[matched] = true
+		matched = ""
+		if _, err := s.writeBuff(answer); err != nil {
+			return "", err
 		}
 	}
-	return nil
 }
 
 // Close cli conn
 func (s *CliConn) Close() error {
-	delete(conns, s.req.Address)
-	if s.t == common.TELNETConn {
+	if s.pool != nil && !s.pool.discard(s) {
+		// closed already
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), beforeCloseTimeout)
+	defer cancel()
+	if err := s.op.BeforeClose(ctx, s); err != nil {
+		logs.Notice(s.req.LogPrefix, "before close hook failed,", err)
+	}
+	return s.closeTransport()
+}
+
+func (s *CliConn) closeTransport() error {
+	s.stopReader()
+	if s.t != common.SSHConn {
 		if s.conn == nil {
 			logs.Info("telnet conn nil when close")
 			return nil
@@ -281,12 +204,16 @@ func (s *CliConn) Close() error {
 		return s.conn.Close()
 	}
 	if s.session != nil {
-		if err := s.session.Close(); err != nil {
-			return err
+		// session of a dead connection fails to close, client still needs closing
+		if err := s.session.Close(); err != nil && err != io.EOF {
+			logs.Notice("close ssh session error,", err)
 		}
 	} else {
 		logs.Notice("ssh session nil when close")
 	}
+	if s.shared != nil {
+		return s.shared.release()
+	}
 	if s.client == nil {
 		logs.Notice("ssh conn nil when close")
 		return nil
@@ -294,13 +221,6 @@ func (s *CliConn) Close() error {
 	return s.client.Close()
 }
 
-func (s *CliConn) read(buff []byte) (int, error) {
-	if s.t == common.SSHConn {
-		return s.r.Read(buff)
-	}
-	return s.conn.Read(buff)
-}
-
 func (s *CliConn) write(b []byte) (int, error) {
 	if s.t == common.SSHConn {
 		return s.w.Write(b)
@@ -309,11 +229,29 @@ func (s *CliConn) write(b []byte) (int, error) {
 }
 
 type readBuffOut struct {
-	err    error
-	ret    string
-	prompt string
+	err     error
+	ret     string
+	prompt  string
+	matched string // name of matched prompt group
+}
+
+// promptGroup named prompt patterns, name is a mode or a login prompt key
+type promptGroup struct {
+	name     string
+	patterns []*regexp.Regexp
 }
 
+// modeGroup prompts of current mode
+func (s *CliConn) modeGroup() promptGroup {
+	return promptGroup{s.mode, s.op.GetPrompts(s.mode)}
+}
+
+// maxConfirms confirmation prompts answered for one command, device asking again and again rejects the answer
+const maxConfirms = 8
+
+// pagerErasure backspaces, carriage returns and cursor moves a device prints to wipe its pager
+var pagerErasure = regexp.MustCompile(`^(?:\x08|\r|\x1b\[[0-9;]*[A-Za-z])(?:(?:[ \x08\r]|\x1b\[[0-9;]*[A-Za-z])*(?:\x08|\r|\x1b\[[0-9;]*[A-Za-z]))?`)
+
 func (s *CliConn) findLastLine(t string) string {
 	scanner := bufio.NewScanner(strings.NewReader(t))
 	var last string
@@ -337,78 +275,249 @@ func (s *CliConn) anyPatternMatches(t string, patterns []*regexp.Regexp) []strin
 	return nil
 }
 
-func (s *CliConn) readLines() *readBuffOut {
-	buf := make([]byte, 1000)
+// readLines read until last line match any group, ctx cancellation interrupts the read
+func (s *CliConn) readLines(ctx context.Context, groups []promptGroup) *readBuffOut {
 	var (
-		waitingString, lastLine string
-		errRes                  error
+		waitingString, lastLine, matched string
+		errRes                           error
+		emitted                          int  // length of output passed to sink
+		paged                            bool // pager continued, next chunk starts with its erasure
+		confirmed                        int  // length of output answered already
+		confirms                         int  // confirmation prompts answered
 	)
+	if s.chunks == nil {
+		s.startReader()
+	}
 	for {
-		n, err := s.read(buf) //this reads the ssh/telnet terminal
-		if err != nil {
+		var c chunk
+		select {
+		case c = <-s.chunks:
+		case <-ctx.Done():
+			errRes = ctx.Err()
+		}
+		if errRes != nil {
+			break
+		}
+		if c.err != nil {
 			// something wrong
-			logs.Error(s.req.LogPrefix, "io.Reader read error,", err)
-			errRes = err
+			logs.Error(s.req.LogPrefix, "io.Reader read error,", c.err)
+			// reader is gone, a new one gets the error again
+			s.stopReader()
+			errRes = &transportError{c.err}
 			break
 		}
 		// for every line
-		current := string(buf[:n])
-		logs.Debug(s.req.LogPrefix, "(", n, ")", current)
+		current := string(c.data)
+		logs.Debug(s.req.LogPrefix, "(", len(c.data), ")", current)
+		if paged {
+			current = pagerErasure.ReplaceAllString(current, "")
+			paged = false
+		}
 		lastLine = s.findLastLine(waitingString + current)
-		if s.op.GetPrompts(s.mode) == nil {
-			logs.Error(s.req.LogPrefix, "no patterns for mode", s.mode)
-			errRes = fmt.Errorf("%s no patterns for mode %s", s.req.LogPrefix, s.mode)
+		for _, g := range groups {
+			if g.patterns == nil {
+				logs.Error(s.req.LogPrefix, "no patterns for", g.name)
+				errRes = fmt.Errorf("%s no patterns for mode %s", s.req.LogPrefix, g.name)
+				break
+			}
+			matches := s.anyPatternMatches(lastLine, g.patterns)
+			if len(matches) > 0 {
+				logs.Info(s.req.LogPrefix, "prompt matched", g.name, ":", matches)
+				waitingString = strings.TrimSuffix(waitingString+current, matches[0])
+				matched = g.name
+				break
+			}
+		}
+		if errRes != nil {
 			break
 		}
-		matches := s.anyPatternMatches(lastLine, s.op.GetPrompts(s.mode))
-		if len(matches) > 0 {
-			logs.Info(s.req.LogPrefix, "prompt matched", s.mode, ":", matches)
-			waitingString = strings.TrimSuffix(waitingString+current, matches[0])
+		if matched != "" {
+			if s.sink != nil && len(waitingString) > emitted {
+				s.sink(waitingString[emitted:])
+			}
 			break
 		}
 		// add current line to result string
 		waitingString += current
+		if p, m := s.pagerMatches(lastLine); p != nil {
+			// drop the pager and ask for the next page
+			logs.Debug(s.req.LogPrefix, "pager matched,", m)
+			waitingString = waitingString[:strings.LastIndex(waitingString, m)]
+			if confirmed > len(waitingString) {
+				confirmed = len(waitingString)
+			}
+			if _, err := s.write([]byte(p.Continue)); err != nil {
+				errRes = &transportError{err}
+				break
+			}
+			paged = true
+		} else if c, m := s.confirmMatches(s.findLastLine(waitingString[confirmed:])); c != nil {
+			if confirms++; confirms > maxConfirms {
+				errRes = fmt.Errorf("%s still asking for confirmation after %d answers, %s", s.req.LogPrefix, maxConfirms, m)
+				break
+			}
+			logs.Info(s.req.LogPrefix, "confirmation matched,", m)
+			answer := c.Answer
+			if !c.Raw {
+				answer += s.op.GetLinebreak()
+			}
+			if _, err := s.write([]byte(answer)); err != nil {
+				errRes = &transportError{err}
+				break
+			}
+			confirmed = len(waitingString)
+		}
+		// complete lines only, the last one may be a prompt
+		if i := strings.LastIndex(waitingString, "\n"); s.sink != nil && i+1 > emitted {
+			s.sink(waitingString[emitted : i+1])
+			emitted = i + 1
+		}
 	}
 	return &readBuffOut{
 		errRes,
 		waitingString,
 		lastLine,
+		matched,
 	}
 }
 
-// return cmd output, prompt, error
-func (s *CliConn) readBuff() (string, string, error) {
-	// buffered chan
-	ch := make(chan *readBuffOut, 1)
-
-	go func() {
-		ch <- s.readLines()
-	}()
-
-	select {
-	case res := <-ch:
-		if res.err == nil {
-			scanner := bufio.NewScanner(strings.NewReader(res.ret))
-			for scanner.Scan() {
-				matches := s.anyPatternMatches(scanner.Text(), s.op.GetErrPatterns())
-				if len(matches) > 0 {
-					logs.Info(s.req.LogPrefix, "err pattern matched,", matches)
-					return "", res.prompt, fmt.Errorf("err pattern matched, %s", matches)
-				}
+// pagerMatches return the operator pager shown at last line and the matched text
+func (s *CliConn) pagerMatches(lastLine string) (*cli.Pager, string) {
+	pagers := s.op.GetPagers()
+	for i := range pagers {
+		if m := pagers[i].Pattern.FindString(lastLine); m != "" {
+			return &pagers[i], m
+		}
+	}
+	return nil, ""
+}
+
+// confirmMatches return the confirmation prompt shown at last line and the matched text
+// answers of request are tried before operator ones
+func (s *CliConn) confirmMatches(lastLine string) (*cli.Confirm, string) {
+	if lastLine == "" {
+		return nil, ""
+	}
+	for _, confirms := range [][]cli.Confirm{s.answers, s.op.GetConfirms()} {
+		for i := range confirms {
+			if m := confirms[i].Pattern.FindString(lastLine); m != "" {
+				return &confirms[i], m
 			}
 		}
-		return res.ret, res.prompt, res.err
-	case <-time.After(s.req.Timeout):
-		return "", "", fmt.Errorf("read stdout timeout after %q", s.req.Timeout)
 	}
+	return nil, ""
+}
+
+// return cmd output, prompt, error
+func (s *CliConn) readBuff(ctx context.Context) (string, string, error) {
+	ret, prompt, _, err := s.expect(ctx, promptGroup{s.mode, s.op.GetPrompts(s.mode)})
+	if err != nil {
+		return ret, prompt, err
+	}
+	if matches := s.errPatternMatches(ret); len(matches) > 0 {
+		logs.Info(s.req.LogPrefix, "err pattern matched,", matches)
+		return "", prompt, fmt.Errorf("err pattern matched, %s", matches)
+	}
+	return ret, prompt, nil
+}
+
+// errPatternMatches return matches of the first output line hitting an operator err pattern
+func (s *CliConn) errPatternMatches(ret string) []string {
+	scanner := bufio.NewScanner(strings.NewReader(ret))
+	for scanner.Scan() {
+		if matches := s.anyPatternMatches(scanner.Text(), s.op.GetErrPatterns()); len(matches) > 0 {
+			return matches
+		}
+	}
+	return nil
+}
+
+// expect read until last line match any group, return output, prompt and name of the matched group
+func (s *CliConn) expect(ctx context.Context, groups ...promptGroup) (string, string, string, error) {
+	return s.expectWithin(ctx, s.req.Timeout, groups...)
+}
+
+// expectWithin is expect with read timeout
+// timeout returns timeoutError, cancellation of ctx returns its error
+func (s *CliConn) expectWithin(ctx context.Context, timeout time.Duration, groups ...promptGroup) (string, string, string, error) {
+	rctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	res := s.readLines(rctx, groups)
+	if res.err != nil && ctx.Err() == nil && rctx.Err() != nil {
+		return res.ret, res.prompt, res.matched, &timeoutError{timeout}
+	}
+	if res.err == nil {
+		s.prompt = res.prompt
+	}
+	return res.ret, res.prompt, res.matched, res.err
 }
 
 func (s *CliConn) writeBuff(cmd string) (int, error) {
 	return s.write([]byte(cmd + s.op.GetLinebreak()))
 }
 
-// Exec execute cli cmds
-func (s *CliConn) Exec() (map[string]string, error) {
+// Exec execute cli cmds, return output by command
+func (s *CliConn) Exec(ctx context.Context) (map[string]string, error) {
+	res, err := s.Run(ctx, nil)
+	return CmdsStd(res), err
+}
+
+// Run execute cli cmds, return results in order of cmds, output is passed to f while it is read if f is not nil
+// cancellation of ctx interrupts the running command
+// if session is lost before any command sent, or request is idempotent, it is re-established and cmds run again
+func (s *CliConn) Run(ctx context.Context, f OutputFunc) ([]protocol.CmdResult, error) {
+	s.output = f
+	defer func() { s.output = nil }()
+	res, err := s.exec(ctx)
+	if _, ok := err.(*timeoutError); ok || (err != nil && ctx.Err() != nil) {
+		// leave the session at a prompt for the next request
+		if ierr := s.interrupt(); ierr != nil {
+			logs.Error(s.req.LogPrefix, "discard session,", ierr)
+			s.Close()
+		}
+		return res, err
+	}
+	lost, ok := err.(*ConnLostError)
+	if !ok {
+		return res, err
+	}
+	if lost.Sent > 0 && !(s.req.Idempotent && readOnly(s.req, s.op)) {
+		logs.Error(s.req.LogPrefix, "session lost after", lost.Sent, "commands sent, not retried")
+		s.Close()
+		return res, err
+	}
+	logs.Notice(s.req.LogPrefix, "session lost, reconnecting...")
+	if err := s.reconnect(ctx); err != nil {
+		s.Close()
+		return res, fmt.Errorf("reconnect failed, %s", err)
+	}
+	return s.exec(ctx)
+}
+
+// CmdsStd return output of commands succeeded by command
+func CmdsStd(res []protocol.CmdResult) map[string]string {
+	cmdstd := make(map[string]string, 0)
+	for _, v := range res {
+		if v.Status == protocol.CmdOK {
+			cmdstd[v.Command] = v.Output
+		}
+	}
+	return cmdstd
+}
+
+// exec run transitions and cmds, results of cmds not run are skipped ones
+func (s *CliConn) exec(ctx context.Context) ([]protocol.CmdResult, error) {
+	res := make([]protocol.CmdResult, 0, len(s.req.Commands))
+	skip := func(err error) ([]protocol.CmdResult, error) {
+		for _, v := range s.req.Commands[len(res):] {
+			res = append(res, protocol.CmdResult{Command: v, Mode: s.mode, Status: protocol.CmdSkipped})
+		}
+		return res, err
+	}
+	if err := s.op.Setup(ctx, s); err != nil {
+		logs.Error(s.req.LogPrefix, "session setup failed,", err)
+		return skip(execErr(0, err))
+	}
 	// transit to target mode
 	if s.req.Mode != s.mode {
 		cmds := s.op.GetTransitions(s.mode, s.req.Mode)
@@ -419,29 +528,122 @@ func (s *CliConn) Exec() (map[string]string, error) {
 			logs.Info(s.req.LogPrefix, "exec", "<", v, ">")
 			if _, err := s.writeBuff(v); err != nil {
 				logs.Error(s.req.LogPrefix, "write buff failed,", err)
-				return nil, fmt.Errorf("write buff failed, %s", err)
+				return skip(&ConnLostError{Err: fmt.Errorf("write buff failed, %s", err)})
 			}
-			_, _, err := s.readBuff()
+			_, _, err := s.readBuff(ctx)
 			if err != nil {
 				logs.Error(s.req.LogPrefix, "readBuff failed,", err)
-				return nil, fmt.Errorf("readBuff failed, %s", err)
+				return skip(execErr(0, err))
 			}
 		}
 	}
-	cmdstd := make(map[string]string, 0)
+	answers, err := compileAnswers(s.req.Answers)
+	if err != nil {
+		return skip(err)
+	}
+	defer func() { s.sink, s.answers = nil, nil }()
 	// do execute cli commands
-	for _, v := range s.req.Commands {
+	for i, v := range s.req.Commands {
 		logs.Info(s.req.LogPrefix, "exec", "<", v, ">")
-		if _, err := s.writeBuff(v); err != nil {
-			logs.Error(s.req.LogPrefix, "write buff failed,", err)
-			return cmdstd, fmt.Errorf("write buff failed, %s", err)
+		if s.output != nil {
+			i, v := i, v
+			clean := func(data string) string { return data }
+			if !s.req.RawOutput {
+				clean = newNormalizer(v, s.op.GetOutputHints()).write
+			}
+			s.sink = func(data string) {
+				if data = clean(data); data != "" {
+					s.output(i, v, data)
+				}
+			}
+		}
+		if a, ok := answers[v]; ok {
+			s.answers = a
+		} else {
+			s.answers = answers[""]
 		}
-		ret, _, err := s.readBuff()
+		r, err := s.run(ctx, v)
+		res = append(res, r)
+		if lost, ok := err.(*ConnLostError); ok {
+			// not sent
+			lost.Sent = i
+			return skip(lost)
+		} else if err != nil {
+			return skip(execErr(i+1, err))
+		}
+	}
+	return res, nil
+}
+
+// run one command in current mode
+func (s *CliConn) run(ctx context.Context, cmd string) (r protocol.CmdResult, err error) {
+	r = protocol.CmdResult{Command: cmd, Mode: s.mode}
+	start := time.Now()
+	defer func() { r.Duration = time.Since(start).Nanoseconds() / int64(time.Millisecond) }()
+	if _, err := s.writeBuff(cmd); err != nil {
+		logs.Error(s.req.LogPrefix, "write buff failed,", err)
+		r.Status = protocol.CmdLost
+		return r, &ConnLostError{Err: fmt.Errorf("write buff failed, %s", err)}
+	}
+	ret, prompt, _, err := s.expect(ctx, s.modeGroup())
+	if !s.req.RawOutput {
+		ret = normalize(cmd, ret, s.op.GetOutputHints())
+	}
+	r.Output, r.Prompt = ret, strings.TrimSpace(prompt)
+	if err != nil {
+		logs.Error(s.req.LogPrefix, "readBuff failed,", err)
+		r.Status = cmdStatus(ctx, err)
+		return r, err
+	}
+	if matches := s.errPatternMatches(ret); len(matches) > 0 {
+		logs.Info(s.req.LogPrefix, "err pattern matched,", matches)
+		r.Status, r.ErrPattern = protocol.CmdFailed, matches[0]
+		return r, fmt.Errorf("err pattern matched, %s", matches)
+	}
+	r.Status = protocol.CmdOK
+	return r, nil
+}
+
+// cmdStatus return status of command failed with err
+func cmdStatus(ctx context.Context, err error) string {
+	switch err.(type) {
+	case *timeoutError:
+		return protocol.CmdTimeout
+	case *transportError:
+		return protocol.CmdLost
+	}
+	if ctx.Err() == context.Canceled {
+		return protocol.CmdCancelled
+	} else if ctx.Err() != nil {
+		return protocol.CmdTimeout
+	}
+	return protocol.CmdFailed
+}
+
+// compileAnswers return request answers by command, answers for all commands follow command specific ones
+func compileAnswers(answers []protocol.Answer) (map[string][]cli.Confirm, error) {
+	var all []cli.Confirm
+	byCmd := make(map[string][]cli.Confirm, 0)
+	for _, v := range answers {
+		re, err := regexp.Compile(v.Expect)
 		if err != nil {
-			logs.Error(s.req.LogPrefix, "readBuff failed,", err)
-			return cmdstd, fmt.Errorf("readBuff failed, %s", err)
+			return nil, fmt.Errorf("invalid answer expect %q, %s", v.Expect, err)
+		}
+		c := cli.Confirm{Pattern: re, Answer: v.Send, Raw: v.Raw}
+		if v.Command == "" {
+			all = append(all, c)
+			continue
 		}
-		cmdstd[v] = ret
+		byCmd[v.Command] = append(byCmd[v.Command], c)
 	}
-	return cmdstd, nil
+	res := make(map[string][]cli.Confirm, len(byCmd))
+	for k, v := range byCmd {
+		res[k] = append(v, all...)
+	}
+	// commands without specific answers
+	res[""] = all
+	return res, nil
 }
+
+// OutputFunc receive output chunk of the index-th command of request
+type OutputFunc func(index int, cmd, data string)
@@ -0,0 +1,273 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// ctrlU erase the line a previous user left half typed, without running it
+	ctrlU = "\x15"
+)
+
+// newConsoleConn connect device console through terminal server port, the line is cleared first if asked
+func newConsoleConn(ctx context.Context, req *protocol.CliRequest, op cli.Operator, dial dialFunc, policy string) (*CliConn, error) {
+	console := req.Console
+	if console == nil {
+		console = &protocol.Console{}
+	}
+	if len(console.ClearCommands) > 0 {
+		if err := clearLine(ctx, req, console, dial, policy); err != nil {
+			return nil, fmt.Errorf("clear console line failed, %s", err)
+		}
+	}
+	nc, err := dial(ctx, "tcp", req.Address)
+	if err != nil {
+		return nil, fmt.Errorf("[ %s ] dial %s error, %s", req.Device, req.Address, err)
+	}
+	var conn net.Conn = nc
+	if !console.Raw {
+		conn = newTelnetConn(nc, common.TerminalType, common.TerminalWidth, common.TerminalHeight)
+	}
+	c := &CliConn{t: common.CONSOLEConn, conn: conn, req: req, op: op, mode: op.GetStartMode()}
+	if err := c.init(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// wake wake console line up and detect the mode it is sitting in, login only if the line asks for it
+func (s *CliConn) wake(ctx context.Context) (string, error) {
+	groups := []promptGroup{
+		{cli.PromptUsername, cli.GetLoginPrompts(s.op, cli.PromptUsername)},
+		{cli.PromptPassword, cli.GetLoginPrompts(s.op, cli.PromptPassword)},
+		{s.mode, s.op.GetPrompts(s.mode)},
+	}
+	// start mode first, then target mode, it may share prompt with others
+	seen := map[string]bool{s.mode: true, "": true}
+	for _, m := range append([]string{s.req.Mode}, s.op.GetModes()...) {
+		if !seen[m] {
+			seen[m] = true
+			groups = append(groups, promptGroup{m, s.op.GetPrompts(m)})
+		}
+	}
+	logs.Info(s.req.LogPrefix, "waking console line up...")
+	if _, err := s.writeBuff(ctrlU); err != nil {
+		return "", err
+	}
+	_, prompt, matched, err := s.expect(ctx, groups...)
+	if err != nil {
+		return "", fmt.Errorf("console line not responding, %s", err)
+	}
+	switch matched {
+	case cli.PromptUsername, cli.PromptPassword:
+		logs.Info(s.req.LogPrefix, "console line asks for login")
+		return s.login(ctx, matched, prompt)
+	case s.mode:
+	default:
+		logs.Info(s.req.LogPrefix, "console line sitting in mode", matched)
+		s.mode = matched
+	}
+	return prompt, nil
+}
+
+// confirmations asked by terminal servers when clearing a line
+var termServerConfirms = []struct {
+	pattern *regexp.Regexp
+	answer  string
+}{
+	{regexp.MustCompile(`\[confirm\] ?$`), ""},
+	{regexp.MustCompile(`(?i)[(\[](y/n|yes/no)[)\]]\??:? ?$`), "y"},
+}
+
+// clearLine run clear commands on terminal server cli
+func clearLine(ctx context.Context, req *protocol.CliRequest, console *protocol.Console, dial dialFunc, policy string) error {
+	pattern := console.ServerPrompt
+	if pattern == "" {
+		pattern = `[>#] ?$`
+	}
+	prompt, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("compile terminal server prompt failed, %s", err)
+	}
+	treq := &protocol.CliRequest{
+		Address:   console.Server,
+		Auth:      console.ServerAuth,
+		Timeout:   req.Timeout,
+		LogPrefix: req.LogPrefix + " [ " + console.Server + " ]",
+	}
+	c := &CliConn{req: treq, op: &termServerOp{prompt: prompt}, mode: "login"}
+	if strings.EqualFold(console.ServerProtocol, "telnet") {
+		nc, err := dial(ctx, "tcp", console.Server)
+		if err != nil {
+			return fmt.Errorf("dial %s error, %s", console.Server, err)
+		}
+		c.t = common.TELNETConn
+		c.conn = newTelnetConn(nc, common.TerminalType, common.TerminalWidth, common.TerminalHeight)
+	} else {
+		client, err := dialSSH(ctx, dial, console.Server, &console.ServerAuth, policy)
+		if err != nil {
+			return err
+		}
+		c.t = common.SSHConn
+		c.client = client
+	}
+	defer c.closeTransport()
+	if _, err := c.open(ctx); err != nil {
+		return err
+	}
+	groups := []promptGroup{{"login", []*regexp.Regexp{prompt}}}
+	answers := make(map[string]string, len(termServerConfirms))
+	for i, v := range termServerConfirms {
+		name := fmt.Sprintf("confirm%d", i)
+		answers[name] = v.answer
+		groups = append(groups, promptGroup{name, []*regexp.Regexp{v.pattern}})
+	}
+	for _, cmd := range console.ClearCommands {
+		logs.Info(treq.LogPrefix, "exec", "<", cmd, ">")
+		if _, err := c.writeBuff(cmd); err != nil {
+			return err
+		}
+		for {
+			_, _, matched, err := c.expect(ctx, groups...)
+			if err != nil {
+				return err
+			}
+			answer, ok := answers[matched]
+			if !ok {
+				// back to prompt
+				break
+			}
+			if _, err := c.writeBuff(answer); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// termServerOp operator of terminal server cli, only its prompt is known
+type termServerOp struct {
+	prompt *regexp.Regexp
+}
+
+func (s *termServerOp) GetTransitions(c, t string) []string {
+	return nil
+}
+
+func (s *termServerOp) GetPrompts(k string) []*regexp.Regexp {
+	if k == "login" {
+		return []*regexp.Regexp{s.prompt}
+	}
+	return nil
+}
+
+func (s *termServerOp) GetModes() []string {
+	return []string{"login"}
+}
+
+func (s *termServerOp) GetKeepaliveCommand() string {
+	return ""
+}
+
+func (s *termServerOp) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+func (s *termServerOp) GetPagers() []cli.Pager {
+	return nil
+}
+
+func (s *termServerOp) GetClosePageCommands() []string {
+	return nil
+}
+
+func (s *termServerOp) GetConfirms() []cli.Confirm {
+	return nil
+}
+
+func (s *termServerOp) GetOutputHints() cli.OutputHints {
+	return cli.OutputHints{}
+}
+
+func (s *termServerOp) Escalate(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *termServerOp) AfterLogin(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *termServerOp) Setup(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *termServerOp) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *termServerOp) GetErrPatterns() []*regexp.Regexp {
+	return nil
+}
+
+func (s *termServerOp) GetLinebreak() string {
+	return "\n"
+}
+
+func (s *termServerOp) GetStartMode() string {
+	return "login"
+}
+
+func (s *termServerOp) GetSSHInitializer() cli.SSHInitializer {
+	return func(c *ssh.Client, req *protocol.CliRequest) (io.Reader, io.WriteCloser, *ssh.Session, error) {
+		session, err := c.NewSession()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("new ssh session failed, %s", err)
+		}
+		r, err := session.StdoutPipe()
+		if err != nil {
+			session.Close()
+			return nil, nil, nil, fmt.Errorf("create stdout pipe failed, %s", err)
+		}
+		w, err := session.StdinPipe()
+		if err != nil {
+			session.Close()
+			return nil, nil, nil, fmt.Errorf("create stdin pipe failed, %s", err)
+		}
+		if err := session.RequestPty(common.TerminalType, 0, common.TerminalWidth, ssh.TerminalModes{}); err != nil {
+			session.Close()
+			return nil, nil, nil, fmt.Errorf("request pty failed, %s", err)
+		}
+		if err := session.Shell(); err != nil {
+			session.Close()
+			return nil, nil, nil, fmt.Errorf("create shell failed, %s", err)
+		}
+		return r, w, session, nil
+	}
+}
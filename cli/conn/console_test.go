@@ -0,0 +1,76 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConsoleWake(t *testing.T) {
+
+	Convey("wake console line", t, func() {
+		client, server := net.Pipe()
+		defer server.Close()
+		op := cli.OperatorManagerInstance.Get("cisco.asa.9.6")
+		req := &protocol.CliRequest{
+			Address:  "192.168.1.10:2033",
+			Protocol: "console",
+			Auth:     protocol.Auth{Username: "admin", Password: "r00tme"},
+			Mode:     "login_enable",
+			Timeout:  time.Second,
+		}
+		c := &CliConn{t: common.CONSOLEConn, conn: client, req: req, op: op, mode: op.GetStartMode()}
+		defer client.Close()
+		r := bufio.NewReader(server)
+
+		Convey("line left in configure mode", func() {
+			go func() {
+				line, _ := r.ReadString('\n')
+				if line == ctrlU+"\n" {
+					server.Write([]byte("\r\nasa(config)# "))
+				}
+			}()
+			prompt, err := c.wake(context.Background())
+			So(err, ShouldBeNil)
+			So(prompt, ShouldEqual, "asa(config)# ")
+			So(c.mode, ShouldEqual, "configure_terminal")
+		})
+
+		Convey("line asks for login", func() {
+			go func() {
+				r.ReadString('\n')
+				server.Write([]byte("\r\nUsername: "))
+				r.ReadString('\n')
+				server.Write([]byte("Password: "))
+				r.ReadString('\n')
+				server.Write([]byte("\r\nasa> "))
+			}()
+			prompt, err := c.wake(context.Background())
+			So(err, ShouldBeNil)
+			So(prompt, ShouldEqual, "asa> ")
+			So(c.mode, ShouldEqual, "login_or_login_enable")
+		})
+	})
+}
@@ -0,0 +1,273 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// dialTimeout tcp connect and ssh handshake timeout
+	dialTimeout = 5 * time.Second
+)
+
+// dialFunc dial network address, directly, through proxy or through a jump host, gives up once ctx is done
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// dialContext run dial till ctx is done, conn made after that is closed
+// for dialers knowing nothing about ctx, like ssh channels and socks5 proxies
+func dialContext(ctx context.Context, dial func() (net.Conn, error)) (net.Conn, error) {
+	type dialed struct {
+		c   net.Conn
+		err error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		c, err := dial()
+		done <- dialed{c, err}
+	}()
+	select {
+	case d := <-done:
+		return d.c, d.err
+	case <-ctx.Done():
+		go func() {
+			if d := <-done; d.c != nil {
+				d.c.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// clientDialer return dial func which open connections through ssh client c
+func clientDialer(c *ssh.Client) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialContext(ctx, func() (net.Conn, error) { return c.Dial(network, address) })
+	}
+}
+
+// dialSSH establish ssh client over connection made by dial, cancelling ctx aborts dialing and handshake
+func dialSSH(ctx context.Context, dial dialFunc, address string, auth *protocol.Auth, policy string) (*ssh.Client, error) {
+	sa, err := newSSHAuth(auth)
+	if err != nil {
+		return nil, err
+	}
+	defer sa.Close()
+	var hostKeyErr error
+	sshConfig := &ssh.ClientConfig{
+		User:            auth.Username,
+		Auth:            sa.methods,
+		HostKeyCallback: hostKeys.Callback(policy, &hostKeyErr),
+		Timeout:         dialTimeout,
+	}
+	sshConfig.SetDefaults()
+	sshConfig.Ciphers = append(sshConfig.Ciphers, []string{"aes128-cbc", "3des-cbc"}...)
+	logs.Info("dialing", address, "with auth methods", sa.names)
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s error, %s", address, err)
+	}
+	// deadlines are ignored by jump host channels, closing conn is what stops a stuck handshake
+	type handshake struct {
+		c     ssh.Conn
+		chans <-chan ssh.NewChannel
+		reqs  <-chan *ssh.Request
+		err   error
+	}
+	done := make(chan handshake, 1)
+	go func() {
+		c, chans, reqs, err := ssh.NewClientConn(conn, address, sshConfig)
+		done <- handshake{c, chans, reqs, err}
+	}()
+	timer := time.NewTimer(dialTimeout)
+	defer timer.Stop()
+	var h handshake
+	select {
+	case h = <-done:
+	case <-timer.C:
+		conn.Close()
+		<-done
+		return nil, fmt.Errorf("dial %s error, ssh handshake timeout", address)
+	case <-ctx.Done():
+		conn.Close()
+		<-done
+		return nil, fmt.Errorf("dial %s error, %s", address, ctx.Err())
+	}
+	if h.err != nil {
+		conn.Close()
+		if hostKeyErr != nil {
+			return nil, hostKeyErr
+		}
+		if isAuthErr(h.err) {
+			return nil, &AuthError{Methods: sa.names, Err: fmt.Errorf("%s, %s", address, h.err)}
+		}
+		return nil, fmt.Errorf("dial %s error, %s", address, h.err)
+	}
+	return ssh.NewClient(h.c, h.chans, h.reqs), nil
+}
+
+// DialSSH establish ssh client to device of req through its proxy and jump hosts, host key is checked by its policy
+func DialSSH(ctx context.Context, req *protocol.CliRequest) (*ssh.Client, error) {
+	policy, err := hostKeyPolicy(req)
+	if err != nil {
+		return nil, err
+	}
+	dial, err := deviceDialer(ctx, req, policy)
+	if err != nil {
+		return nil, err
+	}
+	return dialSSH(ctx, dial, req.Address, &req.Auth, policy)
+}
+
+// DeviceDialer return dial func which reach device of req through its proxy and jump hosts, along with host key policy of req
+// jump host keys are checked by the policy, api transports check device certificates by it
+// jump hosts are connected while ctx lasts, connections are made while ctx passed to dial func lasts
+func DeviceDialer(ctx context.Context, req *protocol.CliRequest) (func(ctx context.Context, network, address string) (net.Conn, error), string, error) {
+	policy, err := hostKeyPolicy(req)
+	if err != nil {
+		return nil, "", err
+	}
+	dial, err := deviceDialer(ctx, req, policy)
+	if err != nil {
+		return nil, "", err
+	}
+	return dial, policy, nil
+}
+
+// bastions ssh clients of jump hosts, shared by devices behind them
+var bastions = &bastionCache{clients: make(map[string]*ssh.Client), dialing: make(map[string]*bastionDial)}
+
+type bastionCache struct {
+	mu      sync.Mutex
+	clients map[string]*ssh.Client  // jump chain key to client of its last hop
+	dialing map[string]*bastionDial // jump chain key to dial in progress, joined by concurrent callers
+}
+
+// bastionDial jump host dial shared by callers of the same chain
+type bastionDial struct {
+	done   chan struct{} // closed when dial finished
+	client *ssh.Client
+	err    error
+}
+
+// deviceDialer return dial func which reach device through proxy and jump hosts of request
+func deviceDialer(ctx context.Context, req *protocol.CliRequest, policy string) (dialFunc, error) {
+	if len(req.JumpHosts) == 0 {
+		return proxyDialer(req.Proxy), nil
+	}
+	client, err := bastions.get(ctx, req.JumpHosts, req.Proxy, policy)
+	if err != nil {
+		return nil, err
+	}
+	return clientDialer(client), nil
+}
+
+// get return client of last jump host, hops not connected yet are dialed through previous ones
+// the first hop is dialed through proxy p
+// the lock is not held while dialing, a slow hop only holds up callers of its own chain
+// callers give up waiting once ctx is done, the hop dial goes on for others and is cached
+func (s *bastionCache) get(ctx context.Context, chain []protocol.JumpHost, p *protocol.Proxy, policy string) (*ssh.Client, error) {
+	var (
+		keys   []string
+		client *ssh.Client
+	)
+	if p != nil {
+		keys = append(keys, p.Type+"://"+p.Address)
+	}
+	dial := proxyDialer(p)
+	for _, hop := range chain {
+		// hops logged in with other credentials are not shared
+		keys = append(keys, hop.Auth.Username+"@"+hop.Address+"~"+credFingerprint(&hop.Auth))
+		key := strings.Join(keys, ">")
+		c, err := s.dial(ctx, key, dial, hop, policy)
+		if err != nil {
+			return nil, err
+		}
+		client = c
+		dial = clientDialer(c)
+	}
+	return client, nil
+}
+
+// dial return cached client of key, or dial hop through dial, concurrent callers of key share one dial
+func (s *bastionCache) dial(ctx context.Context, key string, dial dialFunc, hop protocol.JumpHost, policy string) (*ssh.Client, error) {
+	s.mu.Lock()
+	if c, ok := s.clients[key]; ok {
+		s.mu.Unlock()
+		return c, nil
+	}
+	d, ok := s.dialing[key]
+	if !ok {
+		d = &bastionDial{done: make(chan struct{})}
+		s.dialing[key] = d
+		go s.connect(key, d, dial, hop, policy)
+	}
+	s.mu.Unlock()
+	select {
+	case <-d.done:
+		return d.client, d.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("connect jump host %s error, %s", hop.Address, ctx.Err())
+	}
+}
+
+// connect dial hop of key, bounded by dial timeout rather than ctx of any caller since the dial is shared
+func (s *bastionCache) connect(key string, d *bastionDial, dial dialFunc, hop protocol.JumpHost, policy string) {
+	logs.Info("connecting jump host", key)
+	d.client, d.err = dialSSH(context.Background(), dial, hop.Address, &hop.Auth, policy)
+	s.mu.Lock()
+	delete(s.dialing, key)
+	if d.err == nil {
+		s.clients[key] = d.client
+		go s.watch(key, d.client)
+	}
+	s.mu.Unlock()
+	close(d.done)
+	if d.err != nil {
+		logs.Error("connect jump host", hop.Address, "error,", d.err)
+	}
+}
+
+// watch drop client from cache once it is closed
+func (s *bastionCache) watch(key string, c *ssh.Client) {
+	err := c.Wait()
+	logs.Notice("jump host", key, "disconnected,", err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[key] == c {
+		delete(s.clients, key)
+	}
+}
+
+// closeAll close every jump host client
+func (s *bastionCache) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, c := range s.clients {
+		logs.Info("closing jump host", key)
+		c.Close()
+		delete(s.clients, key)
+	}
+}
@@ -0,0 +1,217 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeBastion ssh server forwarding direct-tcpip channels
+type fakeBastion struct {
+	l      net.Listener
+	config *ssh.ServerConfig
+}
+
+func newFakeBastion() *fakeBastion {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "admin" && string(pass) == "r00tme" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	b := &fakeBastion{l: l, config: config}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBastion) serve() {
+	for {
+		c, err := b.l.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(c)
+	}
+}
+
+func (b *fakeBastion) handle(c net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(c, b.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		var target struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		if nc.ChannelType() != "direct-tcpip" || ssh.Unmarshal(nc.ExtraData(), &target) != nil {
+			nc.Reject(ssh.UnknownChannelType, "direct-tcpip only")
+			continue
+		}
+		tc, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			tc.Close()
+			continue
+		}
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			io.Copy(ch, tc)
+			ch.Close()
+		}()
+		go func() {
+			io.Copy(tc, ch)
+			tc.Close()
+		}()
+	}
+}
+
+func (b *fakeBastion) hop() protocol.JumpHost {
+	return protocol.JumpHost{Address: b.l.Addr().String(), Auth: protocol.Auth{Username: "admin", Password: "r00tme"}}
+}
+
+// newSilentListener accept tcp connections and never answer
+func newSilentListener() net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+	return l
+}
+
+func TestJumpHostDial(t *testing.T) {
+
+	Convey("jump host dial", t, func() {
+		saved := dialTimeout
+		dialTimeout = 500 * time.Millisecond
+		defer func() { dialTimeout = saved }()
+		defer bastions.closeAll()
+		bastion := newFakeBastion()
+		defer bastion.l.Close()
+		silent := newSilentListener()
+		defer silent.Close()
+		auth := &protocol.Auth{Username: "admin", Password: "r00tme"}
+
+		Convey("silent device behind jump host times out", func() {
+			client, err := bastions.get(context.Background(), []protocol.JumpHost{bastion.hop()}, nil, common.HostKeyInsecure)
+			So(err, ShouldBeNil)
+			start := time.Now()
+			_, err = dialSSH(context.Background(), clientDialer(client), silent.Addr().String(), auth, common.HostKeyInsecure)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "handshake timeout")
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+		})
+
+		Convey("slow jump host does not hold up other chains", func() {
+			_, err := bastions.get(context.Background(), []protocol.JumpHost{bastion.hop()}, nil, common.HostKeyInsecure)
+			So(err, ShouldBeNil)
+			slow := []protocol.JumpHost{{Address: silent.Addr().String(), Auth: *auth}}
+			errs := make(chan error, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					_, err := bastions.get(context.Background(), slow, nil, common.HostKeyInsecure)
+					errs <- err
+				}()
+			}
+			time.Sleep(100 * time.Millisecond)
+			start := time.Now()
+			_, err = bastions.get(context.Background(), []protocol.JumpHost{bastion.hop()}, nil, common.HostKeyInsecure)
+			So(err, ShouldBeNil)
+			So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+			So(<-errs, ShouldNotBeNil)
+			So(<-errs, ShouldNotBeNil)
+		})
+
+		Convey("cancelling ctx aborts dialing", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			req := &protocol.CliRequest{
+				Address:       silent.Addr().String(),
+				Auth:          *auth,
+				JumpHosts:     []protocol.JumpHost{bastion.hop()},
+				HostKeyPolicy: common.HostKeyInsecure,
+			}
+			_, err := DialSSH(ctx, req)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, context.DeadlineExceeded.Error())
+			So(time.Since(start), ShouldBeLessThan, 400*time.Millisecond)
+
+			// waiting for a slow jump host
+			ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			slow := []protocol.JumpHost{{Address: silent.Addr().String(), Auth: *auth}}
+			start = time.Now()
+			_, err = bastions.get(ctx, slow, nil, common.HostKeyInsecure)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, context.DeadlineExceeded.Error())
+			So(time.Since(start), ShouldBeLessThan, 400*time.Millisecond)
+			// the dial goes on for later callers
+			_, err = bastions.get(context.Background(), slow, nil, common.HostKeyInsecure)
+			So(err.Error(), ShouldContainSubstring, "handshake timeout")
+		})
+	})
+}
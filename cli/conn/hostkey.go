@@ -0,0 +1,354 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyChangedError device presented a host key different from the known ones
+type HostKeyChangedError struct {
+	Address     string   // device address
+	Fingerprint string   // fingerprint of presented key
+	Known       []string // fingerprints of known keys
+}
+
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf("host key of %s changed, presented %s, known %v", e.Address, e.Fingerprint, e.Known)
+}
+
+// HostKeyUnknownError device has no known host key under strict policy
+type HostKeyUnknownError struct {
+	Address     string // device address
+	Fingerprint string // fingerprint of presented key
+}
+
+func (e *HostKeyUnknownError) Error() string {
+	return fmt.Sprintf("host key %s of %s unknown", e.Fingerprint, e.Address)
+}
+
+// HostKeyStore host keys in OpenSSH known_hosts format
+type HostKeyStore struct {
+	mu    sync.Mutex
+	path  string // known_hosts file path, keys kept in memory only if empty
+	lines []*hostKeyLine
+}
+
+type hostKeyLine struct {
+	text   string        // original line, written back as it is
+	marker string        // @revoked or @cert-authority
+	hosts  []string      // host patterns
+	key    ssh.PublicKey // nil for comments and blank lines
+}
+
+// NewHostKeyStore load known_hosts file, the file is created on first write if not exists
+func NewHostKeyStore(path string) (*HostKeyStore, error) {
+	s := &HostKeyStore{path: path}
+	if path == "" {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		l := &hostKeyLine{text: scanner.Text()}
+		trimmed := strings.TrimSpace(l.text)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			l.marker, l.hosts, l.key, _, _, err = ssh.ParseKnownHosts([]byte(trimmed))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d parse known host failed, %s", path, n, err)
+			}
+		}
+		s.lines = append(s.lines, l)
+	}
+	return s, scanner.Err()
+}
+
+// lookup return keys of lines with marker matching host, marker is empty for plain host keys
+func (s *HostKeyStore) lookup(host, marker string) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	for _, l := range s.lines {
+		if l.key != nil && l.marker == marker && hostMatch(l.hosts, host) {
+			keys = append(keys, l.key)
+		}
+	}
+	return keys
+}
+
+// listed report whether key is one of keys
+func listed(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add record key for address
+func (s *HostKeyStore) Add(address string, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(address, key)
+}
+
+func (s *HostKeyStore) add(address string, key ssh.PublicKey) error {
+	text := knownhosts.Line([]string{address}, key)
+	s.lines = append(s.lines, &hostKeyLine{text: text, hosts: []string{knownhosts.Normalize(address)}, key: key})
+	return s.save()
+}
+
+// Replace remove keys recorded for address and record the new key
+// entries with wildcard patterns are left untouched
+func (s *HostKeyStore) Replace(address string, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host := knownhosts.Normalize(address)
+	lines := s.lines[:0]
+	for _, l := range s.lines {
+		if l.key != nil && l.marker == "" && hostExactMatch(l.hosts, host) {
+			logs.Notice("removing host key", ssh.FingerprintSHA256(l.key), "of", host)
+			continue
+		}
+		lines = append(lines, l)
+	}
+	s.lines = lines
+	return s.add(address, key)
+}
+
+// save write keys to file atomically
+func (s *HostKeyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, l := range s.lines {
+		buf.WriteString(l.text + "\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Callback return ssh host key callback which verify keys by policy
+// the error returned is kept in *error for caller, ssh handshake error does not preserve it
+func (s *HostKeyStore) Callback(policy string, verr *error) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		*verr = s.check(policy, hostname, key)
+		return *verr
+	}
+}
+
+func (s *HostKeyStore) check(policy, address string, key ssh.PublicKey) error {
+	if policy == common.HostKeyInsecure {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host := knownhosts.Normalize(address)
+	revoked := s.lookup(host, "revoked")
+	authorities := s.lookup(host, "cert-authority")
+	if cert, ok := key.(*ssh.Certificate); ok {
+		checker := &ssh.CertChecker{
+			IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+				return listed(authorities, auth) && !listed(revoked, auth)
+			},
+			IsRevoked: func(c *ssh.Certificate) bool { return listed(revoked, c.Key) },
+		}
+		err := checker.CheckHostKey(address, nil, cert)
+		if err == nil {
+			return nil
+		}
+		if len(authorities) > 0 {
+			return fmt.Errorf("host certificate of %s rejected, %s", address, err)
+		}
+		// no authority for host, the certified key is checked as a plain one
+		key = cert.Key
+	}
+	fp := ssh.FingerprintSHA256(key)
+	if listed(revoked, key) {
+		return fmt.Errorf("host key %s of %s is revoked", fp, address)
+	}
+	known := s.lookup(host, "")
+	if listed(known, key) {
+		return nil
+	}
+	if len(known) == 0 {
+		// hosts trusted through authorities are not pinned to plain keys
+		if policy != common.HostKeyTOFU || len(authorities) > 0 {
+			return &HostKeyUnknownError{Address: address, Fingerprint: fp}
+		}
+		logs.Notice("trust host key", fp, "of", address, "on first use")
+		if err := s.add(address, key); err != nil {
+			return fmt.Errorf("record host key of %s failed, %s", address, err)
+		}
+		return nil
+	}
+	var fps []string
+	for _, k := range known {
+		fps = append(fps, ssh.FingerprintSHA256(k))
+	}
+	return &HostKeyChangedError{Address: address, Fingerprint: fp, Known: fps}
+}
+
+// globEscaper quote characters path.Match treats specially besides * and ?
+var globEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`)
+
+// hostMatch report whether any pattern match normalized host, negated patterns take precedence
+func hostMatch(patterns []string, host string) bool {
+	matched := false
+	for _, p := range patterns {
+		negate := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		var ok bool
+		switch {
+		case p == host:
+			ok = true
+		case strings.HasPrefix(p, "|1|"):
+			ok = hashedHostMatch(p, host)
+		default:
+			// known_hosts patterns only have * and ?, brackets of [host]:port are literal
+			ok, _ = path.Match(globEscaper.Replace(p), host)
+		}
+		if ok && negate {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// hostExactMatch report whether host is listed without wildcards
+func hostExactMatch(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if p == host || (strings.HasPrefix(p, "|1|") && hashedHostMatch(p, host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashedHostMatch match host against |1|salt|hash entry
+func hashedHostMatch(entry, host string) bool {
+	parts := strings.Split(entry, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// errHostKeyFetched abort handshake once host key received
+var errHostKeyFetched = fmt.Errorf("host key fetched")
+
+// fetchHostKey return the host key presented by address
+func fetchHostKey(ctx context.Context, dial dialFunc, address string, timeout time.Duration) (ssh.PublicKey, error) {
+	var key ssh.PublicKey
+	sshConfig := &ssh.ClientConfig{
+		User: "netd",
+		HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errHostKeyFetched
+		},
+		Timeout: timeout,
+	}
+	sshConfig.SetDefaults()
+	sshConfig.Ciphers = append(sshConfig.Ciphers, []string{"aes128-cbc", "3des-cbc"}...)
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s error, %s", address, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, _, _, err = ssh.NewClientConn(conn, address, sshConfig)
+	if key == nil {
+		return nil, fmt.Errorf("fetch host key of %s failed, %s", address, err)
+	}
+	return key, nil
+}
+
+// AcceptHostKey replace known keys of device with the key it presents now
+// fingerprint is checked against presented key if not empty
+func AcceptHostKey(ctx context.Context, req *protocol.HostKeyRequest) (string, error) {
+	address, fingerprint := req.Address, req.Fingerprint
+	dial, err := deviceDialer(ctx, &protocol.CliRequest{JumpHosts: req.JumpHosts, Proxy: req.Proxy}, config.HostKeyPolicy)
+	if err != nil {
+		return "", err
+	}
+	key, err := fetchHostKey(ctx, dial, address, req.Timeout)
+	if err != nil {
+		return "", err
+	}
+	fp := ssh.FingerprintSHA256(key)
+	if fingerprint != "" && fingerprint != fp {
+		return fp, &FingerprintMismatchError{Address: address, Fingerprint: fp, Want: fingerprint}
+	}
+	if err := hostKeys.Replace(address, key); err != nil {
+		return fp, fmt.Errorf("save host key of %s failed, %s", address, err)
+	}
+	logs.Notice("host key", fp, "of", address, "accepted")
+	return fp, nil
+}
+
+// FingerprintMismatchError presented host key does not match the expected fingerprint
+type FingerprintMismatchError struct {
+	Address     string
+	Fingerprint string // fingerprint of presented key
+	Want        string // expected fingerprint
+}
+
+func (e *FingerprintMismatchError) Error() string {
+	return fmt.Sprintf("host key of %s is %s, expect %s", e.Address, e.Fingerprint, e.Want)
+}
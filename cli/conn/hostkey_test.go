@@ -0,0 +1,146 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sky-cloud-tec/netd/common"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey() ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// newTestHostCert return host certificate of key for principal signed by ca
+func newTestHostCert(key ssh.PublicKey, ca ssh.Signer, principal string) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{principal},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		panic(err)
+	}
+	return cert
+}
+
+func TestHostKeyStore(t *testing.T) {
+
+	Convey("host key store", t, func() {
+		dir, err := ioutil.TempDir("", "netd")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "known_hosts")
+		store, err := NewHostKeyStore(path)
+		So(err, ShouldBeNil)
+		key, other := newTestHostKey(), newTestHostKey()
+
+		Convey("strict rejects unknown host", func() {
+			err := store.check(common.HostKeyStrict, "192.168.1.1:22", key)
+			So(err, ShouldHaveSameTypeAs, &HostKeyUnknownError{})
+		})
+
+		Convey("tofu records unknown host and rejects changed key", func() {
+			So(store.check(common.HostKeyTOFU, "192.168.1.1:22", key), ShouldBeNil)
+			So(store.check(common.HostKeyStrict, "192.168.1.1:22", key), ShouldBeNil)
+			err := store.check(common.HostKeyTOFU, "192.168.1.1:22", other)
+			So(err, ShouldHaveSameTypeAs, &HostKeyChangedError{})
+			So(store.check(common.HostKeyInsecure, "192.168.1.1:22", other), ShouldBeNil)
+
+			Convey("keys persist and can be replaced", func() {
+				loaded, err := NewHostKeyStore(path)
+				So(err, ShouldBeNil)
+				So(loaded.check(common.HostKeyStrict, "192.168.1.1:22", key), ShouldBeNil)
+				So(loaded.Replace("192.168.1.1:22", other), ShouldBeNil)
+				So(loaded.check(common.HostKeyStrict, "192.168.1.1:22", other), ShouldBeNil)
+				So(loaded.check(common.HostKeyStrict, "192.168.1.1:22", key), ShouldHaveSameTypeAs, &HostKeyChangedError{})
+			})
+		})
+
+		Convey("non default port is kept apart", func() {
+			So(store.check(common.HostKeyTOFU, "192.168.1.1:22", key), ShouldBeNil)
+			So(store.check(common.HostKeyStrict, "192.168.1.1:2222", key), ShouldHaveSameTypeAs, &HostKeyUnknownError{})
+		})
+
+		Convey("non default port keys persist and match", func() {
+			So(store.check(common.HostKeyTOFU, "192.168.1.1:2222", key), ShouldBeNil)
+			So(store.check(common.HostKeyTOFU, "192.168.1.1:2222", key), ShouldBeNil)
+			So(store.check(common.HostKeyTOFU, "192.168.1.1:2222", other), ShouldHaveSameTypeAs, &HostKeyChangedError{})
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(strings.Count(string(data), "[192.168.1.1]:2222"), ShouldEqual, 1)
+
+			loaded, err := NewHostKeyStore(path)
+			So(err, ShouldBeNil)
+			So(loaded.check(common.HostKeyStrict, "192.168.1.1:2222", key), ShouldBeNil)
+			So(loaded.check(common.HostKeyStrict, "192.168.1.1:2222", other), ShouldHaveSameTypeAs, &HostKeyChangedError{})
+			So(hostMatch([]string{"[192.168.1.*]:2222"}, "[192.168.1.1]:2222"), ShouldBeTrue)
+		})
+
+		Convey("cert authority and revoked markers", func() {
+			_, caPriv, _ := ed25519.GenerateKey(rand.Reader)
+			ca, _ := ssh.NewSignerFromKey(caPriv)
+			_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+			otherCA, _ := ssh.NewSignerFromKey(otherPriv)
+			lines := "@cert-authority *.example.com,10.0.0.* " + string(ssh.MarshalAuthorizedKey(ca.PublicKey())) +
+				"@revoked * " + string(ssh.MarshalAuthorizedKey(other))
+			So(ioutil.WriteFile(path, []byte(lines), 0600), ShouldBeNil)
+			loaded, err := NewHostKeyStore(path)
+			So(err, ShouldBeNil)
+
+			So(loaded.check(common.HostKeyStrict, "10.0.0.8:22", newTestHostCert(key, ca, "10.0.0.8")), ShouldBeNil)
+			So(loaded.check(common.HostKeyStrict, "fw.example.com:22", newTestHostCert(key, ca, "fw.example.com")), ShouldBeNil)
+			// wrong principal, untrusted ca, revoked certified key
+			So(loaded.check(common.HostKeyTOFU, "10.0.0.8:22", newTestHostCert(key, ca, "10.0.0.9")), ShouldNotBeNil)
+			So(loaded.check(common.HostKeyTOFU, "10.0.0.8:22", newTestHostCert(key, otherCA, "10.0.0.8")), ShouldNotBeNil)
+			So(loaded.check(common.HostKeyTOFU, "10.0.0.8:22", newTestHostCert(other, ca, "10.0.0.8")), ShouldNotBeNil)
+			// plain keys of hosts under an authority are not pinned
+			So(loaded.check(common.HostKeyTOFU, "10.0.0.8:22", key), ShouldHaveSameTypeAs, &HostKeyUnknownError{})
+			So(loaded.check(common.HostKeyTOFU, "192.168.1.1:22", other), ShouldNotBeNil)
+			data, _ := ioutil.ReadFile(path)
+			So(string(data), ShouldEqual, lines)
+		})
+
+		Convey("hashed and wildcard entries match", func() {
+			line := "|1|IHXZvQMvTcZTUU29+2vXFgx8Frs=|UGccIWfRVDwilMBnA3WJoRAC75Y= " +
+				string(ssh.MarshalAuthorizedKey(key))
+			So(ioutil.WriteFile(path, []byte(line+"10.0.0.* "+string(ssh.MarshalAuthorizedKey(other))), 0600), ShouldBeNil)
+			loaded, err := NewHostKeyStore(path)
+			So(err, ShouldBeNil)
+			So(hashedHostMatch("|1|IHXZvQMvTcZTUU29+2vXFgx8Frs=|UGccIWfRVDwilMBnA3WJoRAC75Y=", "hostname"), ShouldBeTrue)
+			So(loaded.check(common.HostKeyStrict, "hostname:22", key), ShouldBeNil)
+			So(loaded.check(common.HostKeyStrict, "10.0.0.8:22", other), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,150 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+const (
+	exitModeTimeout = 5 * time.Second  // wait for prompt when leaving mode before close
+	idleCheckPeriod = 30 * time.Second // check period if neither keepalive nor timeouts are set
+)
+
+// heartbeat keep idle session alive, close it once idle timeout or max lifetime reached
+func (s *CliConn) heartbeat() {
+	go func() {
+		var lastKeepalive time.Time
+		for {
+			opts := s.pool.options()
+			time.Sleep(checkPeriod(opts))
+			// leased sessions are busy, no need to keep them alive
+			if !s.pool.take(s) {
+				if s.pool.isClosed(s) {
+					return
+				}
+				continue
+			}
+			now := time.Now()
+			if opts.maxLifetime > 0 && now.Sub(s.opened) >= opts.maxLifetime {
+				s.retire("max lifetime reached")
+				return
+			}
+			if opts.idleTimeout > 0 && now.Sub(s.lastUsed) >= opts.idleTimeout && !s.pool.atMin() {
+				s.retire("idle timeout")
+				return
+			}
+			if s.lastUsed.After(lastKeepalive) {
+				lastKeepalive = s.lastUsed
+			}
+			if opts.keepalive != common.KeepaliveNone && now.Sub(lastKeepalive) >= opts.keepaliveInterval {
+				if err := s.keepalive(opts.keepalive); err != nil {
+					logs.Critical(s.req.LogPrefix, "heartbeat error,", err)
+					s.Close()
+					return
+				}
+				lastKeepalive = now
+			}
+			s.pool.put(s)
+		}
+	}()
+}
+
+// checkPeriod return the shortest period of keepalive and timeouts
+func checkPeriod(opts poolOptions) time.Duration {
+	var d time.Duration
+	for _, v := range []time.Duration{opts.idleTimeout, opts.maxLifetime} {
+		if v > 0 && (d == 0 || v < d) {
+			d = v
+		}
+	}
+	if opts.keepalive != common.KeepaliveNone && opts.keepaliveInterval > 0 && (d == 0 || opts.keepaliveInterval < d) {
+		d = opts.keepaliveInterval
+	}
+	if d == 0 {
+		return idleCheckPeriod
+	}
+	if d < time.Second {
+		return time.Second
+	}
+	return d
+}
+
+// keepalive send keepalive@openssh.com request or operator no-op command
+// ssh keepalive falls back to command for telnet and console sessions
+func (s *CliConn) keepalive(kind string) error {
+	if kind == common.KeepaliveSSH && s.t == common.SSHConn {
+		ch := make(chan error, 1)
+		go func() {
+			// any reply means the peer is alive
+			_, _, err := s.client.SendRequest("keepalive@openssh.com", true, nil)
+			ch <- err
+		}()
+		select {
+		case err := <-ch:
+			return err
+		case <-time.After(s.req.Timeout):
+			return fmt.Errorf("keepalive timeout after %q", s.req.Timeout)
+		}
+	}
+	if _, err := s.writeBuff(s.op.GetKeepaliveCommand()); err != nil {
+		return err
+	}
+	_, _, err := s.readBuff(context.Background())
+	return err
+}
+
+// retire leave configuration mode and close session
+func (s *CliConn) retire(reason string) {
+	logs.Info(s.req.LogPrefix, "closing session,", reason)
+	s.exitMode()
+	s.Close()
+}
+
+// exitMode go back to a login mode, so device releases configuration locks held by the session
+func (s *CliConn) exitMode() {
+	if s.mode == s.op.GetStartMode() || strings.HasPrefix(s.mode, "login") {
+		return
+	}
+	for _, m := range s.op.GetModes() {
+		if !strings.HasPrefix(m, "login") {
+			continue
+		}
+		cmds := s.op.GetTransitions(s.mode, m)
+		if len(cmds) == 0 {
+			continue
+		}
+		logs.Info(s.req.LogPrefix, s.mode, "-->", m)
+		for _, v := range cmds {
+			if _, err := s.writeBuff(v); err != nil {
+				logs.Error(s.req.LogPrefix, "exit mode", s.mode, "failed,", err)
+				return
+			}
+			if _, _, _, err := s.expectWithin(context.Background(), exitModeTimeout, promptGroup{m, s.op.GetPrompts(m)}); err != nil {
+				logs.Error(s.req.LogPrefix, "exit mode", s.mode, "failed,", err)
+				return
+			}
+		}
+		s.mode = m
+		return
+	}
+}
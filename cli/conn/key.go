@@ -0,0 +1,56 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/sky-cloud-tec/netd/protocol"
+)
+
+// SessionKey identify sessions a request may reuse
+// sessions are only shared by requests of the same device, path, account, credentials and virtual context
+func SessionKey(req *protocol.CliRequest) string {
+	var path []string
+	if req.Proxy != nil {
+		path = append(path, req.Proxy.Type+"://"+req.Proxy.Address)
+	}
+	for _, hop := range req.JumpHosts {
+		path = append(path, hop.Auth.Username+"@"+hop.Address)
+	}
+	path = append(path, strings.ToLower(req.Protocol)+"://"+req.Auth.Username+"@"+req.Address)
+	return strings.Join(path, ">") + "/" + req.Context + "#" + req.Device + " " + credFingerprint(&req.Auth, req.EnablePwd)
+}
+
+// credFingerprint return digest of credentials, so they are not kept in keys
+func credFingerprint(auth *protocol.Auth, secrets ...string) string {
+	h := sha256.New()
+	for _, v := range append([]string{
+		auth.Method,
+		auth.Password,
+		auth.PrivateKey,
+		auth.Passphrase,
+		auth.Certificate,
+		strconv.FormatBool(auth.Agent),
+	}, secrets...) {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,198 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// Manager owns cli sessions of devices, it is safe for concurrent use
+type Manager struct {
+	mu     sync.Mutex
+	pools  map[string]*pool // session key to its pool
+	closed bool
+}
+
+// NewManager create a connection manager
+func NewManager() *Manager {
+	return &Manager{pools: make(map[string]*pool)}
+}
+
+// manager used by package level functions
+var manager = NewManager()
+
+// Acquire lease cli conn from default manager
+func Acquire(ctx context.Context, req *protocol.CliRequest, op cli.Operator) (*CliConn, error) {
+	return manager.Acquire(ctx, req, op)
+}
+
+// Release return cli conn to default manager
+func Release(c *CliConn) {
+	manager.Release(c)
+}
+
+// Evict close sessions of device in default manager
+func Evict(address string) int {
+	return manager.Evict(address)
+}
+
+// CloseAll close every session of default manager and jump host clients
+func CloseAll() {
+	manager.CloseAll()
+	bastions.closeAll()
+}
+
+// Stats return session statistics of default manager
+func Stats() []protocol.PoolStats {
+	return manager.Stats()
+}
+
+// getPool return pool of device, settings of request are applied
+func (m *Manager) getPool(req *protocol.CliRequest) (*pool, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("connection manager closed")
+	}
+	key := SessionKey(req)
+	p, ok := m.pools[key]
+	if !ok {
+		p = newPool(req)
+		m.pools[key] = p
+	}
+	m.mu.Unlock()
+	if err := p.configure(req.Pool, !ok); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Acquire lease cli conn, a new one is created if no idle one left
+// it could be blocked if max sessions of device are leased, till ctx is cancelled
+func (m *Manager) Acquire(ctx context.Context, req *protocol.CliRequest, op cli.Operator) (*CliConn, error) {
+	if req.Mode == "" {
+		req.Mode = op.GetStartMode()
+	}
+	p, err := m.getPool(req)
+	if err != nil {
+		return nil, err
+	}
+	// configuration requests run one at a time
+	// read-only ones are spread across sessions
+	exclusive := !readOnly(req, op)
+	if exclusive {
+		logs.Info(req.LogPrefix, "Acquiring sema...")
+		select {
+		case p.exclusive <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		logs.Info(req.LogPrefix, "sema acquired")
+	}
+	c, err := p.lease(ctx, req, op)
+	if err != nil {
+		if exclusive {
+			<-p.exclusive
+		}
+		return nil, err
+	}
+	c.req = req
+	c.op = op
+	c.exclusive = exclusive
+	if err := c.probe(ctx); err != nil {
+		c.Close()
+		m.Release(c)
+		return nil, err
+	}
+	return c, nil
+}
+
+// Release return cli conn to its pool
+func (m *Manager) Release(c *CliConn) {
+	if c.exclusive {
+		logs.Info(c.req.LogPrefix, "Releasing sema")
+		c.exclusive = false
+		<-c.pool.exclusive
+		logs.Info(c.req.LogPrefix, "sema released")
+	}
+	c.lastUsed = time.Now()
+	c.pool.put(c)
+}
+
+// Evict close idle sessions of device of every account and context, leased ones are closed when released
+// return number of sessions closed now
+func (m *Manager) Evict(address string) int {
+	m.mu.Lock()
+	var pools []*pool
+	for _, p := range m.pools {
+		if p.address == address {
+			pools = append(pools, p)
+		}
+	}
+	m.mu.Unlock()
+	var n int
+	for _, p := range pools {
+		n += p.evict()
+	}
+	logs.Info("evicted", n, "sessions of", address)
+	return n
+}
+
+// CloseAll close idle sessions of every device and refuse new requests
+// leased sessions are closed when released
+func (m *Manager) CloseAll() {
+	m.mu.Lock()
+	m.closed = true
+	pools := m.pools
+	m.pools = make(map[string]*pool)
+	m.mu.Unlock()
+	for _, p := range pools {
+		logs.Info("closing sessions of", p.address)
+		p.evict()
+	}
+}
+
+// Stats return session statistics of every device, account and context, sorted by address
+func (m *Manager) Stats() []protocol.PoolStats {
+	m.mu.Lock()
+	pools := make([]*pool, 0, len(m.pools))
+	for _, p := range m.pools {
+		pools = append(pools, p)
+	}
+	m.mu.Unlock()
+	stats := make([]protocol.PoolStats, 0, len(pools))
+	for _, p := range pools {
+		stats = append(stats, p.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Address != stats[j].Address {
+			return stats[i].Address < stats[j].Address
+		}
+		if stats[i].Username != stats[j].Username {
+			return stats[i].Username < stats[j].Username
+		}
+		return stats[i].Context < stats[j].Context
+	})
+	return stats
+}
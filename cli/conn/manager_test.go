@@ -0,0 +1,294 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeDevice telnet server behaving like an asa in login mode
+// reply return output of command, or drop the connection
+// command hang prints no prompt till ctrl-c
+// command show paged prints two pages split by an asa pager waiting for space
+// commands of fakeQuestions ask for one line before the prompt
+type fakeDevice struct {
+	l     net.Listener
+	reply func(cmd string) (string, bool)
+}
+
+var fakeQuestions = map[string]string{
+	"reload":          "Proceed with reload? [confirm]",
+	"copy run flash:": "Destination filename [running-config]? ",
+}
+
+func newFakeDevice(reply func(cmd string) (out string, drop bool)) *fakeDevice {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	d := &fakeDevice{l: l, reply: reply}
+	go d.serve()
+	return d
+}
+
+func (d *fakeDevice) addr() string {
+	return d.l.Addr().String()
+}
+
+func (d *fakeDevice) serve() {
+	for {
+		c, err := d.l.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(c)
+	}
+}
+
+func (d *fakeDevice) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	c.Write([]byte("Username: "))
+	if _, err := r.ReadString('\n'); err != nil {
+		return
+	}
+	c.Write([]byte("Password: "))
+	if _, err := r.ReadString('\n'); err != nil {
+		return
+	}
+	c.Write([]byte("\r\nasaNAT> "))
+	var (
+		line   []byte
+		asking bool
+	)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case '\x03':
+			line = nil
+			c.Write([]byte("^C\r\nasaNAT> "))
+			continue
+		case '\n':
+		default:
+			line = append(line, b)
+			continue
+		}
+		cmd := strings.TrimSpace(string(line))
+		line = nil
+		if asking {
+			asking = false
+			c.Write([]byte("\r\nanswered " + cmd + "\r\nasaNAT> "))
+			continue
+		}
+		out := cmd + "\r\n"
+		if q, ok := fakeQuestions[cmd]; ok {
+			asking = true
+			c.Write([]byte(out + q))
+			continue
+		}
+		if cmd == "hang" {
+			// no prompt till interrupted
+			c.Write([]byte(out + "working...\r\n"))
+			continue
+		}
+		if cmd == "show paged" {
+			c.Write([]byte(out + "page 1\r\n<--- More --->"))
+			if b, err := r.ReadByte(); err != nil || b != ' ' {
+				return
+			}
+			c.Write([]byte("\r              \r page 2\r\nasaNAT> "))
+			continue
+		}
+		if cmd != "" && d.reply != nil {
+			ret, drop := d.reply(cmd)
+			if drop {
+				return
+			}
+			out += ret
+		}
+		c.Write([]byte(out + "asaNAT> "))
+	}
+}
+
+func (d *fakeDevice) Close() error {
+	return d.l.Close()
+}
+
+func TestManager(t *testing.T) {
+
+	Convey("connection manager", t, func() {
+		dev := newFakeDevice(func(cmd string) (string, bool) { return "output of " + cmd + "\r\n", false })
+		defer dev.Close()
+		op := cli.OperatorManagerInstance.Get("cisco.asa.9.6")
+		m := NewManager()
+		newReq := func(i int) *protocol.CliRequest {
+			return &protocol.CliRequest{
+				Address:   dev.addr(),
+				Protocol:  "telnet",
+				Auth:      protocol.Auth{Username: "admin", Password: "r00tme"},
+				Mode:      "login",
+				Commands:  []string{fmt.Sprintf("show run %d", i)},
+				Timeout:   2 * time.Second,
+				LogPrefix: fmt.Sprintf("[ test %d ]", i),
+				Pool:      &protocol.Pool{Max: 3},
+			}
+		}
+
+		Convey("concurrent requests share pooled sessions", func() {
+			var wg sync.WaitGroup
+			errs := make(chan error, 20)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					req := newReq(i)
+					c, err := m.Acquire(context.Background(), req, op)
+					if err != nil {
+						errs <- err
+						return
+					}
+					defer m.Release(c)
+					out, err := c.Exec(context.Background())
+					if err != nil {
+						errs <- err
+						return
+					}
+					if !strings.Contains(out[req.Commands[0]], "output of "+req.Commands[0]) {
+						errs <- fmt.Errorf("unexpected output %q", out[req.Commands[0]])
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				So(err, ShouldBeNil)
+			}
+			stats := m.Stats()
+			So(stats, ShouldHaveLength, 1)
+			So(stats[0].Created, ShouldBeBetweenOrEqual, 1, 3)
+			So(stats[0].Leased, ShouldEqual, 0)
+			So(stats[0].Idle, ShouldEqual, stats[0].Created)
+
+			Convey("evict closes idle sessions", func() {
+				So(m.Evict(dev.addr()), ShouldEqual, stats[0].Created)
+				stats := m.Stats()
+				So(stats[0].Idle, ShouldEqual, 0)
+				So(stats[0].Closed, ShouldEqual, stats[0].Created)
+			})
+
+			Convey("evicted leased session is closed on release", func() {
+				c, err := m.Acquire(context.Background(), newReq(0), op)
+				So(err, ShouldBeNil)
+				m.Evict(dev.addr())
+				m.Release(c)
+				stats := m.Stats()
+				So(stats[0].Idle, ShouldEqual, 0)
+				So(stats[0].Leased, ShouldEqual, 0)
+			})
+
+			Convey("closed manager refuses requests", func() {
+				m.CloseAll()
+				So(m.Stats(), ShouldBeEmpty)
+				_, err := m.Acquire(context.Background(), newReq(0), op)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("privileged requests take the exclusive path", func() {
+			req := newReq(0)
+			So(readOnly(req, op), ShouldBeTrue)
+			c, err := m.Acquire(context.Background(), req, op)
+			So(err, ShouldBeNil)
+			So(c.exclusive, ShouldBeFalse)
+			m.Release(c)
+
+			req = newReq(1)
+			req.Mode = "login_enable"
+			So(readOnly(req, op), ShouldBeFalse)
+			c, err = m.Acquire(context.Background(), req, op)
+			So(err, ShouldBeNil)
+			So(c.exclusive, ShouldBeTrue)
+			// read-only requests go on, a second privileged one waits for the first one
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			shared, err := m.Acquire(ctx, newReq(2), op)
+			So(err, ShouldBeNil)
+			m.Release(shared)
+			req = newReq(3)
+			req.Mode = "login_enable"
+			_, err = m.Acquire(ctx, req, op)
+			So(err, ShouldResemble, context.DeadlineExceeded)
+			// another account of the device has its own session key
+			req = newReq(4)
+			req.Auth = protocol.Auth{Username: "operator", Password: "r00tme"}
+			other, err := m.Acquire(context.Background(), req, op)
+			So(err, ShouldBeNil)
+			m.Release(other)
+			req.Mode = "login_enable"
+			ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			other, err = m.Acquire(ctx, req, op)
+			So(err, ShouldBeNil)
+			So(other.exclusive, ShouldBeTrue)
+			m.Release(other)
+			m.Release(c)
+		})
+
+		Convey("sessions are not shared across accounts and contexts", func() {
+			readonly, changed, vdom := newReq(1), newReq(2), newReq(3)
+			readonly.Auth = protocol.Auth{Username: "readonly", Password: "r00tme"}
+			changed.Auth.Password = "changed"
+			vdom.Context = "admin"
+			for _, req := range []*protocol.CliRequest{newReq(0), readonly, changed, vdom} {
+				c, err := m.Acquire(context.Background(), req, op)
+				So(err, ShouldBeNil)
+				m.Release(c)
+			}
+			stats := m.Stats()
+			So(stats, ShouldHaveLength, 4)
+			So(stats[0].Username, ShouldEqual, "admin")
+			So(stats[3].Username, ShouldEqual, "readonly")
+			So(m.Evict(dev.addr()), ShouldEqual, 4)
+		})
+
+		Convey("idle session is closed after idle timeout", func() {
+			req := newReq(0)
+			req.Pool = &protocol.Pool{Max: 1, IdleTimeout: 1, Keepalive: common.KeepaliveNone}
+			c, err := m.Acquire(context.Background(), req, op)
+			So(err, ShouldBeNil)
+			m.Release(c)
+			time.Sleep(2500 * time.Millisecond)
+			stats := m.Stats()
+			So(stats[0].Idle, ShouldEqual, 0)
+			So(stats[0].Closed, ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,106 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sky-cloud-tec/netd/cli"
+)
+
+// escapes ansi csi, osc and two byte escape sequences
+var escapes = regexp.MustCompile(`\x1b(?:\[[0-9;?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-Z\\-_])`)
+
+// normalizer clean output of one command, line by line
+// output passed to write must end at line boundary except the last piece
+type normalizer struct {
+	echo  string          // rest of command echo to drop
+	hints cli.OutputHints // operator hints
+}
+
+func newNormalizer(cmd string, hints cli.OutputHints) *normalizer {
+	n := &normalizer{hints: hints}
+	if !hints.NoEcho {
+		n.echo = strings.TrimSpace(cmd)
+	}
+	return n
+}
+
+// write return the cleaned form of output piece
+func (n *normalizer) write(out string) string {
+	out = escapes.ReplaceAllString(out, "")
+	lines := strings.SplitAfter(out, "\n")
+	var b strings.Builder
+	for _, v := range lines {
+		if v == "" {
+			continue
+		}
+		line, eol := render(strings.TrimSuffix(v, "\n")), strings.HasSuffix(v, "\n")
+		if n.echo != "" {
+			// long command may be echoed in several lines
+			if l := strings.TrimSpace(line); l != "" && strings.HasPrefix(n.echo, l) {
+				n.echo = strings.TrimSpace(strings.TrimPrefix(n.echo, l))
+				continue
+			}
+			n.echo = ""
+		}
+		if cli.Match(n.hints.Noise, line) {
+			continue
+		}
+		b.WriteString(line)
+		if eol {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// render line the way a terminal shows it, \r returns and \b moves cursor back, later characters overwrite
+func render(line string) string {
+	line = strings.TrimRight(line, "\r")
+	if !strings.ContainsAny(line, "\r\b") {
+		return line
+	}
+	var (
+		buf    []rune
+		cursor int
+	)
+	for _, r := range line {
+		switch r {
+		case '\r':
+			cursor = 0
+		case '\b':
+			if cursor > 0 {
+				cursor--
+			}
+		default:
+			if cursor < len(buf) {
+				buf[cursor] = r
+			} else {
+				buf = append(buf, r)
+			}
+			cursor++
+		}
+	}
+	// blanks left by erasure
+	return strings.TrimRight(string(buf), " ")
+}
+
+// normalize return cleaned output of cmd
+func normalize(cmd, out string, hints cli.OutputHints) string {
+	return newNormalizer(cmd, hints).write(out)
+}
@@ -0,0 +1,64 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNormalize(t *testing.T) {
+
+	Convey("normalize output", t, func() {
+		hints := cli.OutputHints{}
+
+		Convey("echo and carriage returns dropped", func() {
+			So(normalize("show clock", "show clock\r\n12:00:01 UTC\r\n", hints), ShouldEqual, "12:00:01 UTC\n")
+		})
+
+		Convey("wrapped echo dropped", func() {
+			So(normalize("show running-config interface GigabitEthernet0/1", "show running-config inte\r\nrface GigabitEthernet0/1\r\n!\r\n", hints), ShouldEqual, "!\n")
+		})
+
+		Convey("echo kept when device does not echo", func() {
+			So(normalize("show", "show\r\n", cli.OutputHints{NoEcho: true}), ShouldEqual, "show\n")
+		})
+
+		Convey("ansi escapes removed", func() {
+			So(normalize("ls", "ls\n\x1b[01;34mdir\x1b[0m \x1b]0;title\x07file\n", hints), ShouldEqual, "dir file\n")
+		})
+
+		Convey("backspaces and carriage returns overwrite", func() {
+			So(normalize("show", "show\r\n--More--\b\b\b\b\b\b\b\b        \b\b\b\b\b\b\b\bline 2\r\n", hints), ShouldEqual, "line 2\n")
+			So(normalize("show", "show\r\n  ---- More ----\r                \rline 3\r\n", hints), ShouldEqual, "line 3\n")
+		})
+
+		Convey("noise lines dropped", func() {
+			hints.Noise = []*regexp.Regexp{regexp.MustCompile(`^\{master:\d+\}$`)}
+			So(normalize("show version", "show version \r\nJunos: 15.1\r\n\r\n{master:0}\r\n", hints), ShouldEqual, "Junos: 15.1\n\n")
+		})
+
+		Convey("streamed pieces match whole output", func() {
+			n := newNormalizer("show log", hints)
+			So(n.write("show log\r\n"), ShouldEqual, "")
+			So(n.write("show log line 1\r\n"), ShouldEqual, "show log line 1\n")
+			So(n.write("line 2\r\n"), ShouldEqual, "line 2\n")
+		})
+	})
+}
@@ -0,0 +1,394 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+	"golang.org/x/crypto/ssh"
+)
+
+// pool cli sessions of one device
+// read-only requests spread across sessions, configuration requests are serialized by exclusive sema
+type pool struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	address  string      // device address
+	device   string      // device identity
+	username string      // account sessions are logged in with
+	context  string      // virtual context
+	opts     poolOptions // session settings
+	idle     []*CliConn  // sessions not leased
+	total    int         // sessions idle, leased or being created
+	waiting  int         // requests waiting for a session
+	gen      int         // bumped on eviction, sessions of older generations are closed on return
+	created  int         // sessions created
+	closed   int         // sessions closed
+
+	exclusive chan struct{} // configuration requests of the session key run one at a time
+}
+
+func newPool(req *protocol.CliRequest) *pool {
+	p := &pool{
+		address:   req.Address,
+		device:    req.Device,
+		username:  req.Auth.Username,
+		context:   req.Context,
+		exclusive: make(chan struct{}, 1),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// poolOptions session settings of a device
+type poolOptions struct {
+	min               int           // sessions kept open
+	max               int           // max sessions
+	multiChannel      bool          // open sessions as channels of one ssh client
+	idleTimeout       time.Duration // close sessions idle for longer, 0 never
+	maxLifetime       time.Duration // close sessions opened for longer, 0 never
+	keepalive         string        // ssh, command or none
+	keepaliveInterval time.Duration
+}
+
+// configure apply pool settings, global ones are used for a new pool if request has none
+func (p *pool) configure(opt *protocol.Pool, created bool) error {
+	if opt == nil && !created {
+		return nil
+	}
+	o := poolOptions{
+		min:               config.PoolMin,
+		max:               config.PoolMax,
+		multiChannel:      config.MultiChannel,
+		idleTimeout:       config.IdleTimeout,
+		maxLifetime:       config.MaxLifetime,
+		keepalive:         config.Keepalive,
+		keepaliveInterval: config.KeepaliveInterval,
+	}
+	if opt != nil {
+		keepalive, err := parseKeepalive(opt.Keepalive)
+		if err != nil {
+			return err
+		}
+		o.min, o.max, o.multiChannel = opt.Min, opt.Max, opt.MultiChannel
+		o.idleTimeout = time.Duration(opt.IdleTimeout) * time.Second
+		o.maxLifetime = time.Duration(opt.MaxLifetime) * time.Second
+		o.keepalive = keepalive
+		if opt.KeepaliveInterval > 0 {
+			o.keepaliveInterval = time.Duration(opt.KeepaliveInterval) * time.Second
+		}
+	}
+	if o.max < 1 {
+		o.max = 1
+	}
+	if o.min > o.max {
+		o.min = o.max
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.opts = o
+	// more sessions may be allowed now
+	p.cond.Broadcast()
+	return nil
+}
+
+// options return current session settings
+func (p *pool) options() poolOptions {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.opts
+}
+
+// readOnly return true if request runs in start mode or a read-only mode operator declares
+// privileged modes like login_enable run reload, copy or clear, they are not read only
+func readOnly(req *protocol.CliRequest, op cli.Operator) bool {
+	if req.Mode == op.GetStartMode() {
+		return true
+	}
+	if ro, ok := op.(cli.ReadOnlyModes); ok {
+		for _, m := range ro.GetReadOnlyModes() {
+			if req.Mode == m {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lease return an idle session or create a new one, block if max sessions are leased
+// cancellation of ctx stops waiting
+func (p *pool) lease(ctx context.Context, req *protocol.CliRequest, op cli.Operator) (*CliConn, error) {
+	p.mu.Lock()
+	if len(p.idle) == 0 && p.total >= p.opts.max {
+		// wake waiters up on cancellation
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				p.mu.Lock()
+				p.cond.Broadcast()
+				p.mu.Unlock()
+			case <-done:
+			}
+		}()
+	}
+	for len(p.idle) == 0 && p.total >= p.opts.max {
+		if err := ctx.Err(); err != nil {
+			p.mu.Unlock()
+			return nil, err
+		}
+		p.waiting++
+		p.cond.Wait()
+		p.waiting--
+	}
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		logs.Info(req.LogPrefix, "cli conn exist")
+		return c, nil
+	}
+	p.total++
+	shared, multiChannel := p.sharedClient(req), p.opts.multiChannel
+	p.mu.Unlock()
+
+	c, err := p.create(ctx, req, op, shared, multiChannel)
+	if err != nil {
+		p.mu.Lock()
+		p.total--
+		p.cond.Signal()
+		p.mu.Unlock()
+		return nil, err
+	}
+	p.adopt(c)
+	p.mu.Lock()
+	if p.total < p.opts.min {
+		go p.warm(req, op)
+	}
+	p.mu.Unlock()
+	return c, nil
+}
+
+// adopt count new session in and start keeping it alive
+func (p *pool) adopt(c *CliConn) {
+	p.mu.Lock()
+	p.created++
+	c.gen = p.gen
+	p.mu.Unlock()
+	c.opened = time.Now()
+	c.lastUsed = c.opened
+	c.heartbeat()
+}
+
+// create open a session, as a new channel of shared client if possible
+// the new ssh client is made shareable if multiChannel is set
+func (p *pool) create(ctx context.Context, req *protocol.CliRequest, op cli.Operator, shared *sharedClient, multiChannel bool) (*CliConn, error) {
+	if shared != nil {
+		logs.Info(req.LogPrefix, "opening session on existing ssh client...")
+		c := &CliConn{t: common.SSHConn, client: shared.Client, shared: shared, req: req, op: op, mode: op.GetStartMode(), pool: p}
+		if err := c.init(ctx); err == nil {
+			return c, nil
+		} else {
+			// device may limit channels per connection
+			logs.Notice(req.LogPrefix, "open session on existing ssh client failed,", err)
+			c.closeTransport()
+		}
+	}
+	c, err := newCliConn(ctx, req, op)
+	if err != nil {
+		return nil, err
+	}
+	c.pool = p
+	if multiChannel && c.t == common.SSHConn {
+		c.shared = &sharedClient{Client: c.client, refs: 1}
+	}
+	return c, nil
+}
+
+// sharedClient return ssh client of any session for opening another channel
+func (p *pool) sharedClient(req *protocol.CliRequest) *sharedClient {
+	if !p.opts.multiChannel || !strings.EqualFold(req.Protocol, "ssh") {
+		return nil
+	}
+	for _, c := range p.idle {
+		if c.shared != nil && c.shared.acquire() {
+			return c.shared
+		}
+	}
+	return nil
+}
+
+// warm open sessions in background till min sessions reached
+func (p *pool) warm(req *protocol.CliRequest, op cli.Operator) {
+	wreq := *req
+	wreq.Commands = nil
+	wreq.LogPrefix = req.LogPrefix + " [ warm ] "
+	for {
+		p.mu.Lock()
+		if p.total >= p.opts.min {
+			p.mu.Unlock()
+			return
+		}
+		p.total++
+		shared, multiChannel := p.sharedClient(&wreq), p.opts.multiChannel
+		p.mu.Unlock()
+		c, err := p.create(context.Background(), &wreq, op, shared, multiChannel)
+		if err != nil {
+			logs.Error(wreq.LogPrefix, "warm session failed,", err)
+			p.mu.Lock()
+			p.total--
+			p.cond.Signal()
+			p.mu.Unlock()
+			return
+		}
+		p.adopt(c)
+		p.put(c)
+	}
+}
+
+// put return session to pool, session opened before last eviction is closed
+func (p *pool) put(c *CliConn) {
+	p.mu.Lock()
+	if c.closed {
+		p.mu.Unlock()
+		return
+	}
+	if c.gen != p.gen {
+		p.mu.Unlock()
+		c.retire("evicted")
+		return
+	}
+	p.idle = append(p.idle, c)
+	p.cond.Signal()
+	p.mu.Unlock()
+}
+
+// take remove session from idle ones, return false if it is leased
+func (p *pool) take(c *CliConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, v := range p.idle {
+		if v == c {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// atMin return true if no more sessions than min are open
+func (p *pool) atMin() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total <= p.opts.min
+}
+
+// isClosed return true if session is dropped from pool
+func (p *pool) isClosed(c *CliConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return c.closed
+}
+
+// discard drop session from pool, return false if it is dropped already
+func (p *pool) discard(c *CliConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	for i, v := range p.idle {
+		if v == c {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			break
+		}
+	}
+	p.total--
+	p.closed++
+	p.cond.Signal()
+	return true
+}
+
+// evict close idle sessions, leased ones are closed on return
+// return number of sessions closed
+func (p *pool) evict() int {
+	p.mu.Lock()
+	p.gen++
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, c := range idle {
+		c.retire("evicted")
+	}
+	return len(idle)
+}
+
+// stats return pool statistics
+func (p *pool) stats() protocol.PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return protocol.PoolStats{
+		Address:  p.address,
+		Device:   p.device,
+		Username: p.username,
+		Context:  p.context,
+		Min:      p.opts.min,
+		Max:      p.opts.max,
+		Idle:     len(p.idle),
+		Leased:   p.total - len(p.idle),
+		Waiting:  p.waiting,
+		Created:  p.created,
+		Closed:   p.closed,
+	}
+}
+
+// sharedClient ssh client sessions are opened on as channels
+type sharedClient struct {
+	*ssh.Client
+	mu   sync.Mutex
+	refs int // sessions using the client
+}
+
+// acquire add a reference, return false if client is closed
+func (s *sharedClient) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refs == 0 {
+		return false
+	}
+	s.refs++
+	return true
+}
+
+// release drop a reference, client is closed with the last one
+func (s *sharedClient) release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	return s.Client.Close()
+}
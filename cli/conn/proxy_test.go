@@ -0,0 +1,86 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// serveConnectProxy accept one CONNECT request and greet like an ssh server right after the response
+func serveConnectProxy(l net.Listener, ch chan<- *http.Request) {
+	c, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if err != nil {
+		return
+	}
+	ch <- req
+	if req.Header.Get("Proxy-Authorization") == "" {
+		c.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+		return
+	}
+	c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nSSH-2.0-Device\r\n"))
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+
+	Convey("http connect proxy", t, func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer l.Close()
+		ch := make(chan *http.Request, 1)
+		go serveConnectProxy(l, ch)
+
+		Convey("tunnel established", func() {
+			p := &protocol.Proxy{Type: "http", Address: l.Addr().String(), Username: "u", Password: "p"}
+			c, err := Dial("tcp", "192.168.1.1:22", p, time.Second)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			req := <-ch
+			So(req.Method, ShouldEqual, http.MethodConnect)
+			So(req.Host, ShouldEqual, "192.168.1.1:22")
+			line, err := bufio.NewReader(c).ReadString('\n')
+			So(err, ShouldBeNil)
+			So(line, ShouldEqual, "SSH-2.0-Device\r\n")
+		})
+
+		Convey("tunnel refused", func() {
+			p := &protocol.Proxy{Type: "http", Address: l.Addr().String()}
+			_, err := Dial("tcp", "192.168.1.1:22", p, time.Second)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "407")
+		})
+	})
+
+	Convey("parse proxy url", t, func() {
+		p, err := ParseProxy("socks5://u:p@10.0.0.1:1080")
+		So(err, ShouldBeNil)
+		So(*p, ShouldResemble, protocol.Proxy{Type: "socks5", Address: "10.0.0.1:1080", Username: "u", Password: "p"})
+		_, err = ParseProxy("ftp://10.0.0.1:21")
+		So(err, ShouldNotBeNil)
+	})
+}
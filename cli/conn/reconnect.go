@@ -0,0 +1,91 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// probeAfter cached sessions idle for longer are probed before use
+const probeAfter = 10 * time.Second
+
+// ConnLostError session transport failed while executing request
+type ConnLostError struct {
+	Sent int // request commands written before failure, they may have been applied
+	Err  error
+}
+
+func (e *ConnLostError) Error() string {
+	return e.Err.Error()
+}
+
+// transportError read or write on session failed
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+// execErr classify error of reading command output
+// transport errors mean session lost, timeout and cancellation are kept for interrupting
+func execErr(sent int, err error) error {
+	switch err.(type) {
+	case *transportError:
+		return &ConnLostError{Sent: sent, Err: fmt.Errorf("readBuff failed, %s", err)}
+	case *timeoutError:
+		return err
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+	return fmt.Errorf("readBuff failed, %s", err)
+}
+
+// probe check cached session is still alive, re-establish it if not
+func (s *CliConn) probe(ctx context.Context) error {
+	if time.Since(s.lastUsed) < probeAfter {
+		return nil
+	}
+	err := s.keepalive(common.KeepaliveSSH)
+	if err == nil {
+		return nil
+	}
+	logs.Notice(s.req.LogPrefix, "cached session dead,", err, ", reconnecting...")
+	return s.reconnect(ctx)
+}
+
+// reconnect replace transport of session with a new one, pool bookkeeping is kept
+func (s *CliConn) reconnect(ctx context.Context) error {
+	s.closeTransport()
+	n, err := newCliConn(ctx, s.req, s.op)
+	if err != nil {
+		return err
+	}
+	// mode and prompt are those the new session detected, not those of the dead one
+	s.t, s.mode, s.prompt = n.t, n.mode, n.prompt
+	s.conn, s.client, s.shared = n.conn, n.client, nil
+	s.session, s.r, s.w = n.session, n.r, n.w
+	s.chunks, s.done = n.chunks, n.done
+	s.opened = time.Now()
+	return nil
+}
@@ -0,0 +1,94 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReconnect(t *testing.T) {
+
+	Convey("session lost mid-request", t, func() {
+		var (
+			mu      sync.Mutex
+			dropped bool
+		)
+		// drop connection the first time show crash runs
+		dev := newFakeDevice(func(cmd string) (string, bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			if cmd == "show crash" && !dropped {
+				dropped = true
+				return "", true
+			}
+			return "output of " + cmd + "\r\n", false
+		})
+		defer dev.Close()
+		op := cli.OperatorManagerInstance.Get("cisco.asa.9.6")
+		m := NewManager()
+		req := &protocol.CliRequest{
+			Address:   dev.addr(),
+			Protocol:  "telnet",
+			Auth:      protocol.Auth{Username: "admin", Password: "r00tme"},
+			Mode:      "login",
+			Commands:  []string{"show version", "show crash"},
+			Timeout:   2 * time.Second,
+			LogPrefix: "[ test ]",
+		}
+
+		Convey("idempotent request is retried on a new session", func() {
+			req.Idempotent = true
+			c, err := m.Acquire(context.Background(), req, op)
+			So(err, ShouldBeNil)
+			out, err := c.Exec(context.Background())
+			m.Release(c)
+			So(err, ShouldBeNil)
+			So(out["show version"], ShouldContainSubstring, "output of show version")
+			So(out["show crash"], ShouldContainSubstring, "output of show crash")
+			stats := m.Stats()
+			So(stats[0].Idle, ShouldEqual, 1)
+		})
+
+		Convey("reconnect resets mode and prompt", func() {
+			c, err := m.Acquire(context.Background(), req, op)
+			So(err, ShouldBeNil)
+			defer m.Release(c)
+			c.mode, c.prompt = "configure_terminal", "asaNAT(config)# "
+			So(c.reconnect(context.Background()), ShouldBeNil)
+			So(c.mode, ShouldEqual, "login")
+			So(c.prompt, ShouldEqual, "asaNAT> ")
+		})
+
+		Convey("other request is not retried", func() {
+			c, err := m.Acquire(context.Background(), req, op)
+			So(err, ShouldBeNil)
+			_, err = c.Exec(context.Background())
+			m.Release(c)
+			So(err, ShouldHaveSameTypeAs, &ConnLostError{})
+			So(err.(*ConnLostError).Sent, ShouldEqual, 2)
+			stats := m.Stats()
+			So(stats[0].Idle, ShouldEqual, 0)
+			So(stats[0].Closed, ShouldEqual, 1)
+		})
+	})
+}
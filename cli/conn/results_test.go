@@ -0,0 +1,72 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResults(t *testing.T) {
+
+	Convey("ordered command results", t, func() {
+		n := 0
+		dev := newFakeDevice(func(cmd string) (string, bool) {
+			if cmd == "bad cmd" {
+				return "ERROR: % Invalid input detected\r\n", false
+			}
+			n++
+			return fmt.Sprintf("output %d\r\n", n), false
+		})
+		defer dev.Close()
+		op := cli.OperatorManagerInstance.Get("cisco.asa.9.6")
+		m := NewManager()
+		defer m.CloseAll()
+		req := &protocol.CliRequest{
+			Address:   dev.addr(),
+			Protocol:  "telnet",
+			Auth:      protocol.Auth{Username: "admin", Password: "r00tme"},
+			Mode:      "login",
+			Commands:  []string{"show run", "show run", "bad cmd", "show version"},
+			Timeout:   2 * time.Second,
+			LogPrefix: "[ test ]",
+		}
+		c, err := m.Acquire(context.Background(), req, op)
+		So(err, ShouldBeNil)
+		res, err := c.Run(context.Background(), nil)
+		m.Release(c)
+		So(err, ShouldNotBeNil)
+		So(len(res), ShouldEqual, 4)
+		So(res[0].Output, ShouldEqual, "output 1\n")
+		So(res[1].Output, ShouldEqual, "output 2\n")
+		for _, r := range res[:2] {
+			So(r.Status, ShouldEqual, protocol.CmdOK)
+			So(r.Prompt, ShouldEqual, "asaNAT>")
+			So(r.Mode, ShouldEqual, "login")
+		}
+		So(res[2].Status, ShouldEqual, protocol.CmdFailed)
+		So(res[2].ErrPattern, ShouldEqual, "ERROR: ")
+		So(res[2].Output, ShouldContainSubstring, "Invalid input")
+		So(res[3].Status, ShouldEqual, protocol.CmdSkipped)
+		So(CmdsStd(res), ShouldResemble, map[string]string{"show run": "output 2\n"})
+	})
+}
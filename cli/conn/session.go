@@ -0,0 +1,72 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/protocol"
+)
+
+// beforeCloseTimeout bounds operator hook on a session being closed, it may be dead already
+const beforeCloseTimeout = 5 * time.Second
+
+// CliConn is the session operator hooks work on
+var _ cli.Session = (*CliConn)(nil)
+
+// Request return request being served
+func (s *CliConn) Request() *protocol.CliRequest {
+	return s.req
+}
+
+// Mode return current cli mode
+func (s *CliConn) Mode() string {
+	return s.mode
+}
+
+// SetMode record mode entered by operator hook
+func (s *CliConn) SetMode(m string) {
+	s.mode = m
+}
+
+// Prompt return last prompt read
+func (s *CliConn) Prompt() string {
+	return s.prompt
+}
+
+// Send write cmd followed by operator linebreak
+func (s *CliConn) Send(cmd string) error {
+	_, err := s.writeBuff(cmd)
+	return err
+}
+
+// Read read until prompt of current mode, output hitting err patterns is an error
+func (s *CliConn) Read(ctx context.Context) (string, error) {
+	ret, _, err := s.readBuff(ctx)
+	return ret, err
+}
+
+// Expect read until prompt of any group, return output and name of the matched group
+func (s *CliConn) Expect(ctx context.Context, groups ...cli.PromptGroup) (string, string, error) {
+	pgs := make([]promptGroup, 0, len(groups))
+	for _, g := range groups {
+		pgs = append(pgs, promptGroup{g.Name, g.Patterns})
+	}
+	ret, _, matched, err := s.expect(ctx, pgs...)
+	return ret, matched, err
+}
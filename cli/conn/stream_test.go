@@ -0,0 +1,65 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStream(t *testing.T) {
+
+	Convey("stream command output", t, func() {
+		dev := newFakeDevice(func(cmd string) (string, bool) {
+			return strings.Repeat("line of "+cmd+"\r\n", 500), false
+		})
+		defer dev.Close()
+		op := cli.OperatorManagerInstance.Get("cisco.asa.9.6")
+		m := NewManager()
+		req := &protocol.CliRequest{
+			Address:   dev.addr(),
+			Protocol:  "telnet",
+			Auth:      protocol.Auth{Username: "admin", Password: "r00tme"},
+			Mode:      "login",
+			Commands:  []string{"show run", "show log"},
+			Timeout:   2 * time.Second,
+			LogPrefix: "[ test ]",
+		}
+		c, err := m.Acquire(context.Background(), req, op)
+		So(err, ShouldBeNil)
+		defer m.Release(c)
+
+		streamed := make([]string, 2)
+		var chunks int
+		res, err := c.Run(context.Background(), func(index int, cmd, data string) {
+			So(cmd, ShouldEqual, req.Commands[index])
+			So(data, ShouldNotContainSubstring, "asaNAT>")
+			streamed[index] += data
+			chunks++
+		})
+		So(err, ShouldBeNil)
+		out := CmdsStd(res)
+		So(chunks, ShouldBeGreaterThan, 2)
+		So(streamed[0], ShouldEqual, out["show run"])
+		So(streamed[1], ShouldEqual, out["show log"])
+	})
+}
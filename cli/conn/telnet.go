@@ -0,0 +1,209 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/sky-cloud-tec/netd/common"
+)
+
+// telnet commands and options, rfc854, rfc1091, rfc1073
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWill = 251
+	telnetWont = 252
+	telnetDo   = 253
+	telnetDont = 254
+	telnetIAC  = 255
+
+	telnetOptEcho  = 1
+	telnetOptSGA   = 3
+	telnetOptTTYPE = 24
+	telnetOptNAWS  = 31
+
+	telnetTTYPEIs   = 0
+	telnetTTYPESend = 1
+)
+
+// telnetConn telnet client connection with option negotiation
+// commands are stripped from data read, IAC in data written is escaped
+type telnetConn struct {
+	net.Conn
+	r      *bufio.Reader
+	wmu    sync.Mutex // negotiation replies are written from read side
+	term   string     // terminal type reported
+	width  int        // window width reported
+	height int        // window height reported
+}
+
+func newTelnetConn(conn net.Conn, term string, width, height int) *telnetConn {
+	return &telnetConn{
+		Conn:   conn,
+		r:      bufio.NewReaderSize(conn, 4096),
+		term:   term,
+		width:  width,
+		height: height,
+	}
+}
+
+// NewTelnetConn wrap conn with the option negotiation device sessions use
+func NewTelnetConn(conn net.Conn) net.Conn {
+	return newTelnetConn(conn, common.TerminalType, common.TerminalWidth, common.TerminalHeight)
+}
+
+// Read read data, negotiation commands are handled and stripped
+func (s *telnetConn) Read(buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b == telnetIAC {
+			c, err := s.r.ReadByte()
+			if err != nil {
+				return n, err
+			}
+			if c != telnetIAC {
+				if err := s.command(c); err != nil {
+					return n, err
+				}
+				if n > 0 && s.r.Buffered() == 0 {
+					break
+				}
+				continue
+			}
+			// escaped 0xff data byte
+		}
+		buf[n] = b
+		n++
+		if s.r.Buffered() == 0 {
+			break
+		}
+	}
+	return n, nil
+}
+
+// Write write data with IAC escaped
+func (s *telnetConn) Write(b []byte) (int, error) {
+	escaped := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+		escaped = append(escaped, c)
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if _, err := s.Conn.Write(escaped); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *telnetConn) send(b ...byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err := s.Conn.Write(b)
+	return err
+}
+
+func (s *telnetConn) command(c byte) error {
+	switch c {
+	case telnetWill, telnetWont, telnetDo, telnetDont:
+		opt, err := s.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		return s.negotiate(c, opt)
+	case telnetSB:
+		return s.subnegotiate()
+	}
+	// GA, NOP and the others carry no option
+	return nil
+}
+
+// negotiate let server echo and suppress go ahead, report terminal type and window size, refuse the rest
+func (s *telnetConn) negotiate(c, opt byte) error {
+	switch c {
+	case telnetWill:
+		if opt == telnetOptEcho || opt == telnetOptSGA {
+			return s.send(telnetIAC, telnetDo, opt)
+		}
+		return s.send(telnetIAC, telnetDont, opt)
+	case telnetDo:
+		switch opt {
+		case telnetOptSGA, telnetOptTTYPE:
+			return s.send(telnetIAC, telnetWill, opt)
+		case telnetOptNAWS:
+			if err := s.send(telnetIAC, telnetWill, opt); err != nil {
+				return err
+			}
+			return s.sendWindowSize()
+		}
+		return s.send(telnetIAC, telnetWont, opt)
+	case telnetDont:
+		return s.send(telnetIAC, telnetWont, opt)
+	}
+	// wont needs no reply
+	return nil
+}
+
+func (s *telnetConn) subnegotiate() error {
+	var data []byte
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == telnetIAC {
+			c, err := s.r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if c == telnetSE {
+				break
+			}
+			b = c
+		}
+		data = append(data, b)
+	}
+	if len(data) == 2 && data[0] == telnetOptTTYPE && data[1] == telnetTTYPESend {
+		reply := []byte{telnetIAC, telnetSB, telnetOptTTYPE, telnetTTYPEIs}
+		reply = append(reply, []byte(s.term)...)
+		return s.send(append(reply, telnetIAC, telnetSE)...)
+	}
+	return nil
+}
+
+func (s *telnetConn) sendWindowSize() error {
+	reply := []byte{telnetIAC, telnetSB, telnetOptNAWS}
+	for _, v := range []int{s.width, s.height} {
+		hi, lo := byte(v>>8), byte(v)
+		// 255 in subnegotiation data must be doubled
+		for _, b := range []byte{hi, lo} {
+			reply = append(reply, b)
+			if b == telnetIAC {
+				reply = append(reply, telnetIAC)
+			}
+		}
+	}
+	return s.send(append(reply, telnetIAC, telnetSE)...)
+}
@@ -0,0 +1,122 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli"
+	_ "github.com/sky-cloud-tec/netd/cli/cisco/asa" // load cisco asa
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func readN(r io.Reader, n int) []byte {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestTelnetNegotiation(t *testing.T) {
+
+	Convey("telnet option negotiation", t, func() {
+		client, server := net.Pipe()
+		defer server.Close()
+		c := newTelnetConn(client, "vt100", 2000, 0)
+		defer c.Close()
+
+		go func() {
+			server.Write([]byte{telnetIAC, telnetDo, telnetOptTTYPE})
+			server.Write([]byte{telnetIAC, telnetSB, telnetOptTTYPE, telnetTTYPESend, telnetIAC, telnetSE})
+			server.Write([]byte{telnetIAC, telnetDo, telnetOptNAWS})
+			server.Write([]byte{telnetIAC, telnetWill, telnetOptEcho})
+			server.Write([]byte{telnetIAC, telnetDo, 39})
+			server.Write([]byte{'o', 'k', telnetIAC, telnetIAC})
+		}()
+		readCh := make(chan []byte, 1)
+		go func() {
+			var data []byte
+			buf := make([]byte, 16)
+			for len(data) < 3 {
+				n, err := c.Read(buf)
+				if err != nil {
+					break
+				}
+				data = append(data, buf[:n]...)
+			}
+			readCh <- data
+		}()
+
+		So(readN(server, 3), ShouldResemble, []byte{telnetIAC, telnetWill, telnetOptTTYPE})
+		So(string(readN(server, 11)), ShouldEqual, string([]byte{telnetIAC, telnetSB, telnetOptTTYPE, telnetTTYPEIs, 'v', 't', '1', '0', '0', telnetIAC, telnetSE}))
+		So(readN(server, 3), ShouldResemble, []byte{telnetIAC, telnetWill, telnetOptNAWS})
+		So(readN(server, 9), ShouldResemble, []byte{telnetIAC, telnetSB, telnetOptNAWS, 0x07, 0xd0, 0, 0, telnetIAC, telnetSE})
+		So(readN(server, 3), ShouldResemble, []byte{telnetIAC, telnetDo, telnetOptEcho})
+		So(readN(server, 3), ShouldResemble, []byte{telnetIAC, telnetWont, 39})
+		So(<-readCh, ShouldResemble, []byte{'o', 'k', telnetIAC})
+	})
+}
+
+func TestTelnetLogin(t *testing.T) {
+
+	Convey("telnet login", t, func() {
+		client, server := net.Pipe()
+		defer server.Close()
+		req := &protocol.CliRequest{
+			Address:  "192.168.1.238:23",
+			Protocol: "telnet",
+			Auth:     protocol.Auth{Username: "admin", Password: "r00tme"},
+			Timeout:  time.Second,
+		}
+		op := cli.OperatorManagerInstance.Get("cisco.asa.9.6")
+		c := &CliConn{t: common.TELNETConn, conn: newTelnetConn(client, "vt100", 2000, 0), req: req, op: op, mode: op.GetStartMode()}
+		defer c.conn.Close()
+		r := bufio.NewReader(server)
+
+		Convey("credentials accepted", func() {
+			go func() {
+				server.Write([]byte("\r\nUser Access Verification\r\n\r\nUsername: "))
+				r.ReadString('\n')
+				server.Write([]byte("Password: "))
+				r.ReadString('\n')
+				server.Write([]byte("\r\nType help or '?' for a list of available commands.\r\nasaNAT> "))
+			}()
+			prompt, err := c.login(context.Background(), "", "")
+			So(err, ShouldBeNil)
+			So(prompt, ShouldEqual, "asaNAT> ")
+		})
+
+		Convey("credentials rejected", func() {
+			go func() {
+				server.Write([]byte("Username: "))
+				r.ReadString('\n')
+				server.Write([]byte("Password: "))
+				r.ReadString('\n')
+				server.Write([]byte("\r\nLogin invalid\r\n\r\nUsername: "))
+			}()
+			_, err := c.login(context.Background(), "", "")
+			So(err, ShouldHaveSameTypeAs, &AuthError{})
+		})
+	})
+}
@@ -0,0 +1,486 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxInlineSize max file content carried in request or response
+const maxInlineSize = 16 << 20
+
+// TooLargeError file exceeds size limit
+type TooLargeError struct {
+	Size  int64 // file size, or bytes read when transfer stopped
+	Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("file size %d exceeds limit %d", e.Size, e.Limit)
+}
+
+// ChecksumError file content does not match expected checksum
+type ChecksumError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("sha256 %s, expected %s", e.Actual, e.Expected)
+}
+
+// LocalPathError local file path rejected
+type LocalPathError struct {
+	Path string
+	Err  error
+}
+
+func (e *LocalPathError) Error() string {
+	return fmt.Sprintf("local path %s rejected, %s", e.Path, e.Err)
+}
+
+// TransferResult ...
+type TransferResult struct {
+	Size     int64  // bytes transferred
+	Checksum string // sha256 hex of content
+	Content  []byte // file got when local path is empty
+}
+
+// ProgressFunc receive bytes transferred and file size, size is -1 if unknown
+type ProgressFunc func(done, total int64)
+
+// fileTransport remote file access over ssh connection
+type fileTransport interface {
+	// open return reader of remote file and its size, -1 if unknown
+	open(name string) (io.ReadCloser, int64, error)
+	// create write size bytes of r to remote file, reports whether the file was created before an error
+	create(name string, size int64, r io.Reader) (bool, error)
+	// remove remote file, best effort
+	remove(name string) error
+	Close() error
+}
+
+// Transfer copy file between netd host and device, over ssh connection made the way cli sessions are
+func Transfer(ctx context.Context, req *protocol.TransferRequest, progress ProgressFunc) (*TransferResult, error) {
+	method := strings.ToLower(req.Method)
+	if method == "" {
+		method = common.TransferSFTP
+	}
+	if method != common.TransferSFTP && method != common.TransferSCP {
+		return nil, fmt.Errorf("transfer method %s not support", req.Method)
+	}
+	direction := strings.ToLower(req.Direction)
+	if direction != common.TransferGet && direction != common.TransferPut {
+		return nil, fmt.Errorf("transfer direction %s not support", req.Direction)
+	}
+	local, err := localPath(req.Local)
+	if err != nil {
+		return nil, err
+	}
+	client, err := DialSSH(ctx, &req.CliRequest)
+	if err != nil {
+		logs.Error(req.LogPrefix, "dial", req.Address, "error", err)
+		return nil, err
+	}
+	defer client.Close()
+	// closing client aborts transfer
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-stop:
+		}
+	}()
+	var t fileTransport
+	if method == common.TransferSFTP {
+		c, err := sftp.NewClient(client)
+		if err != nil {
+			return nil, fmt.Errorf("start sftp failed, %s", err)
+		}
+		t = &sftpTransport{c}
+	} else {
+		t = &scpTransport{client}
+	}
+	defer t.Close()
+	logs.Info(req.LogPrefix, method, direction, req.Remote)
+	var res *TransferResult
+	if direction == common.TransferGet {
+		res, err = getFile(t, req, local, progress)
+	} else {
+		res, err = putFile(t, req, local, progress)
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return res, err
+}
+
+// sizeLimit request limit, no more than global one
+func sizeLimit(req *protocol.TransferRequest) int64 {
+	if req.MaxSize > 0 && req.MaxSize < config.TransferMaxSize {
+		return req.MaxSize
+	}
+	return config.TransferMaxSize
+}
+
+// localPath return p under transfer dir, empty if p is empty
+func localPath(p string) (string, error) {
+	if p == "" {
+		return "", nil
+	}
+	if config.TransferDir == "" {
+		return "", &LocalPathError{p, fmt.Errorf("transfer dir not set")}
+	}
+	// rooted before cleaning, .. never leaves transfer dir
+	return filepath.Join(config.TransferDir, filepath.Clean("/"+p)), nil
+}
+
+func getFile(t fileTransport, req *protocol.TransferRequest, local string, progress ProgressFunc) (*TransferResult, error) {
+	limit := sizeLimit(req)
+	if local == "" && limit > maxInlineSize {
+		limit = maxInlineSize
+	}
+	r, size, err := t.open(req.Remote)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r != nil {
+			r.Close()
+		}
+	}()
+	if size > limit {
+		return nil, &TooLargeError{size, limit}
+	}
+	var (
+		buf bytes.Buffer
+		dst io.Writer = &buf
+		tmp *os.File
+	)
+	if local != "" {
+		if err := os.MkdirAll(filepath.Dir(local), 0750); err != nil {
+			return nil, err
+		}
+		// renamed when complete and verified
+		if tmp, err = ioutil.TempFile(filepath.Dir(local), "."+filepath.Base(local)+".part"); err != nil {
+			return nil, err
+		}
+		defer func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}()
+		dst = tmp
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, h, &progressWriter{total: size, f: progress}), io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed, %s", req.Remote, err)
+	}
+	if n > limit {
+		return nil, &TooLargeError{n, limit}
+	}
+	// scp reports errors after the data in its final ack
+	err, r = r.Close(), nil
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed, %s", req.Remote, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if req.Checksum != "" && !strings.EqualFold(req.Checksum, sum) {
+		return nil, &ChecksumError{req.Checksum, sum}
+	}
+	res := &TransferResult{Size: n, Checksum: sum}
+	if local == "" {
+		res.Content = buf.Bytes()
+		return res, nil
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func putFile(t fileTransport, req *protocol.TransferRequest, local string, progress ProgressFunc) (*TransferResult, error) {
+	limit := sizeLimit(req)
+	var src io.ReadSeeker = bytes.NewReader(req.Content)
+	if local != "" {
+		f, err := os.Open(local)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		src = f
+	}
+	// size and checksum checked before anything sent
+	h := sha256.New()
+	size, err := io.Copy(h, io.LimitReader(src, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > limit {
+		return nil, &TooLargeError{size, limit}
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if req.Checksum != "" && !strings.EqualFold(req.Checksum, sum) {
+		return nil, &ChecksumError{req.Checksum, sum}
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	pw := &progressWriter{total: size, f: progress}
+	r := io.TeeReader(io.LimitReader(src, size), pw)
+	if created, err := t.create(req.Remote, size, r); err != nil {
+		// a file failed before being created or written may be an older one, it is kept
+		if !created || pw.done == 0 {
+			return nil, err
+		}
+		if rerr := t.remove(req.Remote); rerr != nil {
+			logs.Notice(req.LogPrefix, "remove incomplete", req.Remote, "failed,", rerr)
+		}
+		return nil, err
+	}
+	return &TransferResult{Size: size, Checksum: sum}, nil
+}
+
+// progressWriter count bytes written and report them
+type progressWriter struct {
+	done  int64
+	total int64
+	f     ProgressFunc
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.done += int64(len(b))
+	if w.f != nil {
+		w.f(w.done, w.total)
+	}
+	return len(b), nil
+}
+
+type sftpTransport struct {
+	*sftp.Client
+}
+
+func (s *sftpTransport) open(name string) (io.ReadCloser, int64, error) {
+	f, err := s.Open(name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open %s failed, %s", name, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return f, -1, nil
+	}
+	return f, fi.Size(), nil
+}
+
+func (s *sftpTransport) create(name string, size int64, r io.Reader) (bool, error) {
+	f, err := s.Create(name)
+	if err != nil {
+		return false, fmt.Errorf("create %s failed, %s", name, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return true, fmt.Errorf("write %s failed, %s", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return true, fmt.Errorf("close %s failed, %s", name, err)
+	}
+	// stored size, devices with full flash may truncate silently
+	fi, err := s.Stat(name)
+	if err != nil {
+		return true, fmt.Errorf("stat %s failed, %s", name, err)
+	}
+	if fi.Size() != size {
+		return true, fmt.Errorf("%s stored %d bytes of %d", name, fi.Size(), size)
+	}
+	return true, nil
+}
+
+func (s *sftpTransport) remove(name string) error {
+	return s.Remove(name)
+}
+
+// scpTransport run scp in source or sink mode on device
+type scpTransport struct {
+	client *ssh.Client
+}
+
+// scpSession scp command running on device
+type scpSession struct {
+	*ssh.Session
+	r *bufio.Reader
+	w io.WriteCloser
+}
+
+func (s *scpTransport) start(cmd string) (*scpSession, error) {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("new ssh session failed, %s", err)
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.Start(cmd); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("start %s failed, %s", cmd, err)
+	}
+	return &scpSession{Session: session, r: bufio.NewReader(r), w: w}, nil
+}
+
+// ack read response of remote scp, 0 ok, 1 warning or 2 error followed by message
+func (s *scpSession) ack() error {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+	msg, _ := s.r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+}
+
+func (s *scpTransport) open(name string) (io.ReadCloser, int64, error) {
+	session, err := s.start("scp -f " + scpQuote(name))
+	if err != nil {
+		return nil, 0, err
+	}
+	// ready to receive file header
+	if _, err := session.w.Write([]byte{0}); err != nil {
+		session.Close()
+		return nil, 0, err
+	}
+	header, err := session.r.ReadString('\n')
+	if err != nil {
+		session.Close()
+		return nil, 0, fmt.Errorf("read scp header failed, %s", err)
+	}
+	if header[0] == 1 || header[0] == 2 {
+		session.Close()
+		return nil, 0, fmt.Errorf("scp: %s", strings.TrimSpace(header[1:]))
+	}
+	// C<mode> <size> <name>
+	fields := strings.Fields(header)
+	if header[0] != 'C' || len(fields) < 3 {
+		session.Close()
+		return nil, 0, fmt.Errorf("unexpected scp header %q", header)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		session.Close()
+		return nil, 0, fmt.Errorf("unexpected scp header %q", header)
+	}
+	if _, err := session.w.Write([]byte{0}); err != nil {
+		session.Close()
+		return nil, 0, err
+	}
+	return &scpReader{session, &io.LimitedReader{R: session.r, N: size}}, size, nil
+}
+
+// scpReader content of file sent by remote scp
+type scpReader struct {
+	session *scpSession
+	*io.LimitedReader
+}
+
+func (s *scpReader) Close() error {
+	defer s.session.Close()
+	if s.N > 0 {
+		// stopped early
+		return nil
+	}
+	// end of file ack
+	if err := s.session.ack(); err != nil {
+		return err
+	}
+	s.session.w.Write([]byte{0})
+	s.session.w.Close()
+	return s.session.Wait()
+}
+
+func (s *scpTransport) create(name string, size int64, r io.Reader) (bool, error) {
+	session, err := s.start("scp -t " + scpQuote(name))
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+	if err := session.ack(); err != nil {
+		return false, err
+	}
+	if _, err := fmt.Fprintf(session.w, "C0644 %d %s\n", size, path.Base(name)); err != nil {
+		return false, err
+	}
+	// file is opened by remote scp once header acked
+	if err := session.ack(); err != nil {
+		return false, err
+	}
+	if _, err := io.CopyN(session.w, r, size); err != nil {
+		return true, fmt.Errorf("write %s failed, %s", name, err)
+	}
+	if _, err := session.w.Write([]byte{0}); err != nil {
+		return true, err
+	}
+	if err := session.ack(); err != nil {
+		return true, err
+	}
+	session.w.Close()
+	return true, session.Wait()
+}
+
+func (s *scpTransport) remove(name string) error {
+	return fmt.Errorf("scp can not remove files")
+}
+
+func (s *scpTransport) Close() error {
+	return nil
+}
+
+// scpQuote quote file name for remote shell if needed
+func scpQuote(name string) string {
+	if !strings.ContainsAny(name, " '\"\\$`;&|<>*?") {
+		return name
+	}
+	return "'" + strings.Replace(name, "'", `'\''`, -1) + "'"
+}
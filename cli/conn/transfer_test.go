@@ -0,0 +1,334 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conn
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeFileServer ssh server with sftp subsystem and scp command on local files
+type fakeFileServer struct {
+	l      net.Listener
+	config *ssh.ServerConfig
+}
+
+func newFakeFileServer() *fakeFileServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "admin" && string(pass) == "r00tme" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := &fakeFileServer{l: l, config: config}
+	go s.serve()
+	return s
+}
+
+func (s *fakeFileServer) serve() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *fakeFileServer) handle(c net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer ch.Close()
+			for r := range reqs {
+				switch r.Type {
+				case "subsystem":
+					r.Reply(true, nil)
+					srv, err := sftp.NewServer(ch)
+					if err != nil {
+						return
+					}
+					srv.Serve()
+					return
+				case "exec":
+					r.Reply(true, nil)
+					cmd := string(r.Payload[4:])
+					status := uint32(0)
+					if err := fakeSCP(ch, cmd); err != nil {
+						status = 1
+					}
+					ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+					return
+				default:
+					r.Reply(false, nil)
+				}
+			}
+		}()
+	}
+}
+
+// fakeSCP scp -t or scp -f of one file
+func fakeSCP(ch ssh.Channel, cmd string) error {
+	r := bufio.NewReader(ch)
+	name := strings.TrimSpace(cmd[len("scp -t "):])
+	if strings.HasPrefix(cmd, "scp -t ") {
+		ch.Write([]byte{0})
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		size, _ := strconv.ParseInt(strings.Fields(header)[1], 10, 64)
+		ch.Write([]byte{0})
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.CopyN(f, r, size); err != nil {
+			return err
+		}
+		r.ReadByte()
+		ch.Write([]byte{0})
+		return nil
+	}
+	r.ReadByte()
+	f, err := os.Open(name)
+	if err != nil {
+		ch.Write([]byte("\x01scp: " + name + ": No such file or directory\n"))
+		return err
+	}
+	defer f.Close()
+	fi, _ := f.Stat()
+	fmt.Fprintf(ch, "C0644 %d %s\n", fi.Size(), filepath.Base(name))
+	r.ReadByte()
+	io.Copy(ch, f)
+	if strings.HasSuffix(name, ".broken") {
+		// failure reported after the data
+		ch.Write([]byte("\x01scp: " + name + ": Input/output error\n"))
+		return nil
+	}
+	ch.Write([]byte{0})
+	r.ReadByte()
+	return nil
+}
+
+func (s *fakeFileServer) Close() error {
+	return s.l.Close()
+}
+
+func TestTransfer(t *testing.T) {
+
+	Convey("file transfer", t, func() {
+		srv := newFakeFileServer()
+		defer srv.Close()
+		remote, err := ioutil.TempDir("", "netd-remote")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(remote)
+		local, err := ioutil.TempDir("", "netd-local")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(local)
+		saved := *config
+		config.TransferDir = local
+		defer func() { *config = saved }()
+
+		content := []byte("hostname asa\ninterface GigabitEthernet0/0\n")
+		sum := sha256.Sum256(content)
+		checksum := hex.EncodeToString(sum[:])
+		newReq := func(method, direction, name string) *protocol.TransferRequest {
+			return &protocol.TransferRequest{
+				CliRequest: protocol.CliRequest{
+					Address:       srv.l.Addr().String(),
+					Auth:          protocol.Auth{Username: "admin", Password: "r00tme"},
+					HostKeyPolicy: common.HostKeyInsecure,
+					Timeout:       5 * time.Second,
+					LogPrefix:     "[ test ]",
+				},
+				Method:    method,
+				Direction: direction,
+				Remote:    filepath.Join(remote, name),
+			}
+		}
+
+		for _, method := range []string{common.TransferSFTP, common.TransferSCP} {
+			method := method
+			Convey("put and get with "+method, func() {
+				var reported int64
+				req := newReq(method, common.TransferPut, "startup-config")
+				req.Content = content
+				req.Checksum = checksum
+				res, err := Transfer(context.Background(), req, func(done, total int64) { reported = done })
+				So(err, ShouldBeNil)
+				So(res.Size, ShouldEqual, len(content))
+				So(reported, ShouldEqual, len(content))
+				stored, _ := ioutil.ReadFile(req.Remote)
+				So(string(stored), ShouldEqual, string(content))
+
+				req = newReq(method, common.TransferGet, "startup-config")
+				req.Local = "../backup/asa.cfg"
+				res, err = Transfer(context.Background(), req, nil)
+				So(err, ShouldBeNil)
+				So(res.Checksum, ShouldEqual, checksum)
+				got, err := ioutil.ReadFile(filepath.Join(local, "backup", "asa.cfg"))
+				So(err, ShouldBeNil)
+				So(string(got), ShouldEqual, string(content))
+			})
+		}
+
+		Convey("content returned inline", func() {
+			So(ioutil.WriteFile(filepath.Join(remote, "running-config"), content, 0644), ShouldBeNil)
+			res, err := Transfer(context.Background(), newReq("", common.TransferGet, "running-config"), nil)
+			So(err, ShouldBeNil)
+			So(string(res.Content), ShouldEqual, string(content))
+		})
+
+		Convey("scp error after data", func() {
+			So(ioutil.WriteFile(filepath.Join(remote, "image.broken"), content, 0644), ShouldBeNil)
+			req := newReq(common.TransferSCP, common.TransferGet, "image.broken")
+			req.Local = "image.bin"
+			_, err := Transfer(context.Background(), req, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Input/output error")
+			_, err = os.Stat(filepath.Join(local, "image.bin"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("checksum mismatch", func() {
+			So(ioutil.WriteFile(filepath.Join(remote, "running-config"), content, 0644), ShouldBeNil)
+			req := newReq(common.TransferSFTP, common.TransferGet, "running-config")
+			req.Local = "running-config"
+			req.Checksum = strings.Repeat("0", 64)
+			_, err := Transfer(context.Background(), req, nil)
+			So(err, ShouldHaveSameTypeAs, &ChecksumError{})
+			_, err = os.Stat(filepath.Join(local, "running-config"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("size limit", func() {
+			req := newReq(common.TransferSCP, common.TransferPut, "image.bin")
+			req.Content = content
+			req.MaxSize = 10
+			_, err := Transfer(context.Background(), req, nil)
+			So(err, ShouldHaveSameTypeAs, &TooLargeError{})
+			_, err = os.Stat(req.Remote)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("local path without transfer dir", func() {
+			config.TransferDir = ""
+			req := newReq(common.TransferSFTP, common.TransferGet, "running-config")
+			req.Local = "running-config"
+			_, err := Transfer(context.Background(), req, nil)
+			So(err, ShouldHaveSameTypeAs, &LocalPathError{})
+		})
+	})
+}
+
+// failingTransport create fails after sending sent bytes, created tells whether the file was created
+type failingTransport struct {
+	created bool
+	sent    int64
+	removed []string
+}
+
+func (s *failingTransport) open(name string) (io.ReadCloser, int64, error) {
+	return nil, 0, fmt.Errorf("open %s failed", name)
+}
+
+func (s *failingTransport) create(name string, size int64, r io.Reader) (bool, error) {
+	io.CopyN(ioutil.Discard, r, s.sent)
+	return s.created, fmt.Errorf("write %s failed", name)
+}
+
+func (s *failingTransport) remove(name string) error {
+	s.removed = append(s.removed, name)
+	return nil
+}
+
+func (s *failingTransport) Close() error {
+	return nil
+}
+
+func TestPutFileFailure(t *testing.T) {
+
+	Convey("failed upload", t, func() {
+		req := &protocol.TransferRequest{Remote: "flash:/startup-config"}
+		req.Content = []byte("hostname asa\n")
+
+		Convey("existing remote file is kept if create fails", func() {
+			ft := &failingTransport{}
+			_, err := putFile(ft, req, "", nil)
+			So(err, ShouldNotBeNil)
+			So(ft.removed, ShouldBeEmpty)
+		})
+
+		Convey("created file is kept if nothing written", func() {
+			ft := &failingTransport{created: true}
+			_, err := putFile(ft, req, "", nil)
+			So(err, ShouldNotBeNil)
+			So(ft.removed, ShouldBeEmpty)
+		})
+
+		Convey("partially written file is removed", func() {
+			ft := &failingTransport{created: true, sent: 4}
+			_, err := putFile(ft, req, "", nil)
+			So(err, ShouldNotBeNil)
+			So(ft.removed, ShouldResemble, []string{req.Remote})
+		})
+	})
+}
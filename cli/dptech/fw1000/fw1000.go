@@ -16,6 +16,7 @@
 package dptech
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
@@ -35,6 +36,10 @@ type opFW1000 struct {
 	transitions map[string][]string
 	prompts     map[string][]*regexp.Regexp
 	errs        []*regexp.Regexp
+	pagers      []cli.Pager
+	confirms    []cli.Confirm
+	hints       cli.OutputHints
+	closePage   []string // commands disabling pager
 }
 
 func createopFW1000() cli.Operator {
@@ -54,6 +59,9 @@ func createopFW1000() cli.Operator {
 		errs: []*regexp.Regexp{
 			regexp.MustCompile("% Unknown command\\."),
 		},
+		pagers: []cli.Pager{
+			{Pattern: regexp.MustCompile(`-- ?More ?-- ?$`), Continue: " "},
+		},
 		lineBeak: "\n",
 	}
 }
@@ -81,6 +89,51 @@ func (s *opFW1000) GetLinebreak() string {
 	return s.lineBeak
 }
 
+func (s *opFW1000) GetModes() []string {
+	return []string{"login", "configure"}
+}
+
+func (s *opFW1000) GetKeepaliveCommand() string {
+	// empty line
+	return ""
+}
+
+func (s *opFW1000) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+func (s *opFW1000) GetPagers() []cli.Pager {
+	return s.pagers
+}
+
+func (s *opFW1000) GetClosePageCommands() []string {
+	return s.closePage
+}
+
+func (s *opFW1000) GetConfirms() []cli.Confirm {
+	return s.confirms
+}
+
+func (s *opFW1000) GetOutputHints() cli.OutputHints {
+	return s.hints
+}
+
+func (s *opFW1000) Escalate(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opFW1000) AfterLogin(ctx context.Context, session cli.Session) error {
+	return cli.ClosePage(ctx, s, session, cli.ModeGroup(s, session))
+}
+
+func (s *opFW1000) Setup(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opFW1000) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
 func (s *opFW1000) GetStartMode() string {
 	return "login"
 }
@@ -15,35 +15,48 @@
 
 package fortigate
 
-import(
+import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/sky-cloud-tec/netd/cli"
 	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
 	"golang.org/x/crypto/ssh"
 )
 
 type opFortinet struct {
-	lineBreak   string // /r/n \n
+	lineBreak   string       // /r/n \n
+	mu          sync.RWMutex // guards transitions and prompts, vdom modes are added while other sessions read them
 	transitions map[string][]string
-	prompts 	map[string][]*regexp.Regexp
-	errs   		[]*regexp.Regexp
+	prompts     map[string][]*regexp.Regexp
+	errs        []*regexp.Regexp
+	pagers      []cli.Pager
+	confirms    []cli.Confirm
+	hints       cli.OutputHints
+	closePage   []string // commands disabling pager
 }
-func init()  {
+
+// anyPrompt prompt of root, vdom, global or config sections, close page commands walk through them
+var anyPrompt = cli.PromptGroup{Name: "any", Patterns: []*regexp.Regexp{regexp.MustCompile(`[[:alnum:]]{1,}[[:alnum:]-_]{0,} (\([^)]+\) )?# $`)}}
+
+func init() {
 	cli.OperatorManagerInstance.Register(`(?i)fortinet\.FortiGate-VM64-KVM\..*`, createOpfortinet())
 }
 
-func createOpfortinet() cli.Operator{
+func createOpfortinet() cli.Operator {
 	loginPrompt := regexp.MustCompile(`[[:alnum:]]{1,}[[:alnum:]-_]{0,} # $`)
-	return &opFortinet {
-		transitions: map[string][]string {
-		},
-		prompts: map[string][]*regexp.Regexp {
-			"login": 	 {loginPrompt},
+	return &opFortinet{
+		transitions: map[string][]string{},
+		prompts: map[string][]*regexp.Regexp{
+			"login": {loginPrompt},
 		},
-		errs: []*regexp.Regexp {
+		errs: []*regexp.Regexp{
 			regexp.MustCompile("^Unknown action 0$"),
 			regexp.MustCompile("^command parse error"),
 			regexp.MustCompile("^value parse error"),
@@ -52,11 +65,20 @@ func createOpfortinet() cli.Operator{
 			regexp.MustCompile("^entry not found in datasource"),
 			regexp.MustCompile("^node_check_object fail"),
 		},
+		pagers: []cli.Pager{
+			{Pattern: regexp.MustCompile(`--More-- ?$`), Continue: " "},
+		},
+		confirms: []cli.Confirm{
+			{Pattern: regexp.MustCompile(`\(y/n\) ?$`), Answer: "y", Raw: true},
+		},
+		closePage: []string{"config system console", "set output standard", "end"},
 		lineBreak: "\n",
 	}
 }
 
 func (s *opFortinet) GetPrompts(k string) []*regexp.Regexp {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if v, ok := s.prompts[k]; ok {
 		return v
 	}
@@ -65,6 +87,8 @@ func (s *opFortinet) GetPrompts(k string) []*regexp.Regexp {
 
 func (s *opFortinet) GetTransitions(c, t string) []string {
 	k := c + "->" + t
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if v, ok := s.transitions[k]; ok {
 		return v
 	}
@@ -75,27 +99,105 @@ func (s *opFortinet) GetErrPatterns() []*regexp.Regexp {
 	return s.errs
 }
 
+func (s *opFortinet) GetModes() []string {
+	// vdom modes are added on demand
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	modes := make([]string, 0, len(s.prompts))
+	for k := range s.prompts {
+		modes = append(modes, k)
+	}
+	sort.Strings(modes)
+	return modes
+}
+
+func (s *opFortinet) GetKeepaliveCommand() string {
+	// empty line
+	return ""
+}
+
+func (s *opFortinet) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+func (s *opFortinet) GetPagers() []cli.Pager {
+	return s.pagers
+}
+
+func (s *opFortinet) GetClosePageCommands() []string {
+	return s.closePage
+}
+
+func (s *opFortinet) GetConfirms() []cli.Confirm {
+	return s.confirms
+}
+
+func (s *opFortinet) GetOutputHints() cli.OutputHints {
+	return s.hints
+}
+
+func (s *opFortinet) Escalate(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opFortinet) AfterLogin(ctx context.Context, session cli.Session) error {
+	req := session.Request()
+	if pts := s.GetPrompts(req.Mode); pts == nil || !strings.Contains(pts[0].String(), req.Mode) {
+		//no vdom
+		return cli.ClosePage(ctx, s, session, anyPrompt)
+	}
+	logs.Debug(req.LogPrefix, "entering domain global...")
+	if err := session.Send("config global"); err != nil {
+		return err
+	}
+	global := cli.PromptGroup{Name: "global", Patterns: []*regexp.Regexp{regexp.MustCompile(`\(global\) # $`)}}
+	if _, _, err := session.Expect(ctx, global); err != nil {
+		return err
+	}
+	if err := cli.ClosePage(ctx, s, session, anyPrompt); err != nil {
+		return err
+	}
+	logs.Debug(req.LogPrefix, "exiting vdom global ...")
+	if err := session.Send("end"); err != nil {
+		return err
+	}
+	_, err := session.Read(ctx)
+	return err
+}
+
+func (s *opFortinet) Setup(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opFortinet) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
 func (s *opFortinet) GetStartMode() string {
 	return "login"
 }
 
-func (s *opFortinet) GetLinebreak() string{
+func (s *opFortinet) GetLinebreak() string {
 	return s.lineBreak
 }
 
+// addVdom add prompt and transitions of vdom mode if it has none
+func (s *opFortinet) addVdom(mode string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.prompts[mode]; ok {
+		return
+	}
+	s.prompts[mode] = []*regexp.Regexp{
+		regexp.MustCompile(`[[:alnum:]]{1,}[[:alnum:]-_]{0,} \(` + mode + `\) # $`),
+	}
+	s.transitions["login->"+mode] = []string{"config vdom\n\t" + "edit " + mode}
+	s.transitions[mode+"->"+"login"] = []string{"end"}
+}
+
 func (s *opFortinet) GetSSHInitializer() cli.SSHInitializer {
-	return func (c *ssh.Client, req *protocol.CliRequest) (io.Reader, io.WriteCloser, *ssh.Session, error) {
-		if s.GetPrompts(req.Mode) == nil {
-			// no pattern for this mode
-			// try insert
-			s.prompts[req.Mode] = []*regexp.Regexp{
-			 regexp.MustCompile(`[[:alnum:]]{1,}[[:alnum:]-_]{0,} \(`+ req.Mode + `\) # $`),
-			}
-			s.transitions["login->"+req.Mode] = []string{"config vdom\n\t" +
-			 "edit " + req.Mode +
-			 ``}
-			s.transitions[req.Mode+"->"+"login"] = []string{"end"}
-		}
+	return func(c *ssh.Client, req *protocol.CliRequest) (io.Reader, io.WriteCloser, *ssh.Session, error) {
+		s.addVdom(req.Mode)
 		var err error
 		session, err := c.NewSession()
 		if err != nil {
@@ -119,4 +221,3 @@ func (s *opFortinet) GetSSHInitializer() cli.SSHInitializer {
 		return r, w, session, nil
 	}
 }
-
@@ -0,0 +1,50 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fortigate
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVdomModes(t *testing.T) {
+
+	Convey("vdom modes added while other sessions read them", t, func() {
+		op := createOpfortinet().(*opFortinet)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(2)
+			vdom := fmt.Sprintf("vdom%d", i%4)
+			go func() {
+				defer wg.Done()
+				op.addVdom(vdom)
+			}()
+			go func() {
+				defer wg.Done()
+				op.GetModes()
+				op.GetPrompts(vdom)
+				op.GetTransitions("login", vdom)
+			}()
+		}
+		wg.Wait()
+		So(op.GetModes(), ShouldResemble, []string{"login", "vdom0", "vdom1", "vdom2", "vdom3"})
+		So(op.GetPrompts("vdom1")[0].MatchString("FGT-1 (vdom1) # "), ShouldBeTrue)
+		So(op.GetTransitions("vdom1", "login"), ShouldResemble, []string{"end"})
+	})
+}
@@ -16,6 +16,7 @@
 package sg6000
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
@@ -35,6 +36,10 @@ type opHillstone struct {
 	transitions map[string][]string
 	prompts     map[string][]*regexp.Regexp
 	errs        []*regexp.Regexp
+	pagers      []cli.Pager
+	confirms    []cli.Confirm
+	hints       cli.OutputHints
+	closePage   []string // commands disabling pager
 }
 
 func createOpHillstone() cli.Operator {
@@ -56,7 +61,14 @@ func createOpHillstone() cli.Operator {
 			regexp.MustCompile(`\^-+unrecognized keyword\s+`),
 			regexp.MustCompile(`^Error:\s+`),
 		},
-		lineBeak: "\n",
+		pagers: []cli.Pager{
+			{Pattern: regexp.MustCompile(`--More-- ?$`), Continue: " "},
+		},
+		confirms: []cli.Confirm{
+			{Pattern: regexp.MustCompile(`y/\[n\]: ?$`), Answer: "y"},
+		},
+		closePage: []string{"terminal length 0"},
+		lineBeak:  "\n",
 	}
 }
 
@@ -83,6 +95,51 @@ func (s *opHillstone) GetLinebreak() string {
 	return s.lineBeak
 }
 
+func (s *opHillstone) GetModes() []string {
+	return []string{"login", "configure"}
+}
+
+func (s *opHillstone) GetKeepaliveCommand() string {
+	// empty line
+	return ""
+}
+
+func (s *opHillstone) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+func (s *opHillstone) GetPagers() []cli.Pager {
+	return s.pagers
+}
+
+func (s *opHillstone) GetClosePageCommands() []string {
+	return s.closePage
+}
+
+func (s *opHillstone) GetConfirms() []cli.Confirm {
+	return s.confirms
+}
+
+func (s *opHillstone) GetOutputHints() cli.OutputHints {
+	return s.hints
+}
+
+func (s *opHillstone) Escalate(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opHillstone) AfterLogin(ctx context.Context, session cli.Session) error {
+	return cli.ClosePage(ctx, s, session, cli.ModeGroup(s, session))
+}
+
+func (s *opHillstone) Setup(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opHillstone) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
 func (s *opHillstone) GetStartMode() string {
 	return "login"
 }
@@ -16,6 +16,7 @@
 package usg
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
@@ -35,6 +36,10 @@ type opUsg6000V struct {
 	transitions map[string][]string
 	prompts     map[string][]*regexp.Regexp
 	errs        []*regexp.Regexp
+	pagers      []cli.Pager
+	confirms    []cli.Confirm
+	hints       cli.OutputHints
+	closePage   []string // commands disabling pager
 }
 
 func createopUsg6000V() cli.Operator {
@@ -54,7 +59,14 @@ func createopUsg6000V() cli.Operator {
 		errs: []*regexp.Regexp{
 			regexp.MustCompile("^ ?Error: ?"),
 		},
-		lineBeak: "\n",
+		pagers: []cli.Pager{
+			{Pattern: regexp.MustCompile(`-{4} More -{4}$`), Continue: " "},
+		},
+		confirms: []cli.Confirm{
+			{Pattern: regexp.MustCompile(`(?i)\[y/n\]: ?$`), Answer: "y"},
+		},
+		closePage: []string{"screen-length 0 temporary"},
+		lineBeak:  "\n",
 	}
 }
 
@@ -81,6 +93,51 @@ func (s *opUsg6000V) GetLinebreak() string {
 	return s.lineBeak
 }
 
+func (s *opUsg6000V) GetModes() []string {
+	return []string{"login", "system_View"}
+}
+
+func (s *opUsg6000V) GetKeepaliveCommand() string {
+	// empty line
+	return ""
+}
+
+func (s *opUsg6000V) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+func (s *opUsg6000V) GetPagers() []cli.Pager {
+	return s.pagers
+}
+
+func (s *opUsg6000V) GetClosePageCommands() []string {
+	return s.closePage
+}
+
+func (s *opUsg6000V) GetConfirms() []cli.Confirm {
+	return s.confirms
+}
+
+func (s *opUsg6000V) GetOutputHints() cli.OutputHints {
+	return s.hints
+}
+
+func (s *opUsg6000V) Escalate(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opUsg6000V) AfterLogin(ctx context.Context, session cli.Session) error {
+	return cli.ClosePage(ctx, s, session, cli.ModeGroup(s, session))
+}
+
+func (s *opUsg6000V) Setup(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opUsg6000V) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
 func (s *opUsg6000V) GetStartMode() string {
 	return "login"
 }
@@ -16,6 +16,7 @@
 package srx
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
@@ -35,6 +36,10 @@ type opJunos struct {
 	transitions map[string][]string
 	prompts     map[string][]*regexp.Regexp
 	errs        []*regexp.Regexp
+	pagers      []cli.Pager
+	confirms    []cli.Confirm
+	hints       cli.OutputHints
+	closePage   []string // commands disabling pager
 }
 
 func createOpJunos() cli.Operator {
@@ -66,7 +71,21 @@ func createOpJunos() cli.Operator {
 			regexp.MustCompile("\\^$"),
 			regexp.MustCompile("^error:"),
 		},
-		lineBeak: "\n",
+		pagers: []cli.Pager{
+			{Pattern: regexp.MustCompile(`---\(more( \d+%)?\)---$`), Continue: " "},
+		},
+		confirms: []cli.Confirm{
+			// device default, requests opt in to yes by answers
+			{Pattern: regexp.MustCompile(`\[yes,no\] \(no\) ?$`), Answer: "no"},
+		},
+		hints: cli.OutputHints{
+			Noise: []*regexp.Regexp{
+				regexp.MustCompile(`^\{(master|primary|secondary|backup)(:\d+)?\}$`),
+				regexp.MustCompile(`^\[edit( .*)?\]$`),
+			},
+		},
+		closePage: []string{"set cli screen-length 0"},
+		lineBeak:  "\n",
 	}
 }
 
@@ -93,6 +112,51 @@ func (s *opJunos) GetLinebreak() string {
 	return s.lineBeak
 }
 
+func (s *opJunos) GetModes() []string {
+	return []string{"login", "configure", "configure_private", "configure_exclusive"}
+}
+
+func (s *opJunos) GetKeepaliveCommand() string {
+	// empty line
+	return ""
+}
+
+func (s *opJunos) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+func (s *opJunos) GetPagers() []cli.Pager {
+	return s.pagers
+}
+
+func (s *opJunos) GetClosePageCommands() []string {
+	return s.closePage
+}
+
+func (s *opJunos) GetConfirms() []cli.Confirm {
+	return s.confirms
+}
+
+func (s *opJunos) GetOutputHints() cli.OutputHints {
+	return s.hints
+}
+
+func (s *opJunos) Escalate(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opJunos) AfterLogin(ctx context.Context, session cli.Session) error {
+	return cli.ClosePage(ctx, s, session, cli.ModeGroup(s, session))
+}
+
+func (s *opJunos) Setup(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opJunos) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
 func (s *opJunos) GetStartMode() string {
 	return "login"
 }
@@ -16,6 +16,7 @@
 package ssg
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
@@ -35,6 +36,10 @@ type opScreenOS struct {
 	transitions map[string][]string
 	prompts     map[string][]*regexp.Regexp
 	errs        []*regexp.Regexp
+	pagers      []cli.Pager
+	confirms    []cli.Confirm
+	hints       cli.OutputHints
+	closePage   []string // commands disabling pager
 }
 
 func createOpScreenOS() cli.Operator {
@@ -43,6 +48,9 @@ func createOpScreenOS() cli.Operator {
 		transitions: map[string][]string{},
 		prompts: map[string][]*regexp.Regexp{
 			"login": {loginPrompt},
+			// telnet login
+			cli.PromptUsername: {regexp.MustCompile("login: ?$")},
+			cli.PromptPassword: {regexp.MustCompile("password: ?$")},
 		},
 
 		errs: []*regexp.Regexp{
@@ -52,6 +60,12 @@ func createOpScreenOS() cli.Operator {
 			regexp.MustCompile("^Service: Not found"),
 			regexp.MustCompile("^Failed command -"),
 		},
+		pagers: []cli.Pager{
+			{Pattern: regexp.MustCompile(`--- more ---$`), Continue: " "},
+		},
+		confirms: []cli.Confirm{
+			{Pattern: regexp.MustCompile(`\(y/\[n\]\) ?$`), Answer: "y", Raw: true},
+		},
 		lineBeak: "\n",
 	}
 }
@@ -79,6 +93,51 @@ func (s *opScreenOS) GetLinebreak() string {
 	return s.lineBeak
 }
 
+func (s *opScreenOS) GetModes() []string {
+	return []string{"login"}
+}
+
+func (s *opScreenOS) GetKeepaliveCommand() string {
+	// empty line
+	return ""
+}
+
+func (s *opScreenOS) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+func (s *opScreenOS) GetPagers() []cli.Pager {
+	return s.pagers
+}
+
+func (s *opScreenOS) GetClosePageCommands() []string {
+	return s.closePage
+}
+
+func (s *opScreenOS) GetConfirms() []cli.Confirm {
+	return s.confirms
+}
+
+func (s *opScreenOS) GetOutputHints() cli.OutputHints {
+	return s.hints
+}
+
+func (s *opScreenOS) Escalate(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opScreenOS) AfterLogin(ctx context.Context, session cli.Session) error {
+	return cli.ClosePage(ctx, s, session, cli.ModeGroup(s, session))
+}
+
+func (s *opScreenOS) Setup(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opScreenOS) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
 func (s *opScreenOS) GetStartMode() string {
 	return "login"
 }
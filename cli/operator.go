@@ -16,6 +16,7 @@
 package cli
 
 import (
+	"context"
 	"io"
 	"log"
 	"regexp"
@@ -29,12 +30,65 @@ import (
 type Operator interface {
 	GetTransitions(c, t string) []string
 	GetPrompts(m string) []*regexp.Regexp
+	GetModes() []string
 	GetErrPatterns() []*regexp.Regexp
 	GetSSHInitializer() SSHInitializer
 	GetLinebreak() string
 	GetStartMode() string
+	GetKeepaliveCommand() string    // no-op command sent to keep session alive
+	GetInterrupt() string           // written as is to abort a running command, e.g. ctrl-c
+	GetPagers() []Pager             // pager prompts answered while reading output
+	GetClosePageCommands() []string // commands disabling pager, failures are ignored
+	GetConfirms() []Confirm         // confirmation prompts answered by default while reading output
+	GetOutputHints() OutputHints    // how command output is cleaned
+	// Escalate enter privileged mode request needs, runs once start mode reached
+	Escalate(ctx context.Context, s Session) error
+	// AfterLogin session setup after Escalate, e.g. disabling pager
+	AfterLogin(ctx context.Context, s Session) error
+	// Setup runs before every request on the session, e.g. output format
+	Setup(ctx context.Context, s Session) error
+	// BeforeClose runs before transport of session closed, errors are logged only
+	BeforeClose(ctx context.Context, s Session) error
 }
 
+// ReadOnlyModes optional operator interface, modes besides start mode which do not change device state
+// requests in them share pooled sessions, the others run one at a time
+type ReadOnlyModes interface {
+	GetReadOnlyModes() []string
+}
+
+// Pager pager prompt shown when output exceeds terminal height
+type Pager struct {
+	Pattern  *regexp.Regexp // matches last line of output while paused
+	Continue string         // written as is to show more
+}
+
+// Confirm confirmation prompt and its answer
+type Confirm struct {
+	Pattern *regexp.Regexp // matches last line of output while waiting
+	Answer  string         // reply, followed by linebreak unless Raw
+	Raw     bool           // reply written as is, for single key prompts
+}
+
+// OutputHints vendor specifics of cleaning command output
+type OutputHints struct {
+	NoEcho bool             // commands are not echoed back
+	Noise  []*regexp.Regexp // lines dropped, e.g. mode banners printed before prompt
+}
+
+// CtrlC interrupt of most device cli
+const CtrlC = "\x03"
+
+// prompt keys of login automation, operators declare them in prompts along with modes
+const (
+	// PromptUsername username prompt
+	PromptUsername = "username"
+	// PromptPassword password prompt
+	PromptPassword = "password"
+	// PromptEnablePassword privileged mode password prompt
+	PromptEnablePassword = "enable_password"
+)
+
 var (
 	// OperatorManagerInstance is OperatorManager instance
 	OperatorManagerInstance *OperatorManager
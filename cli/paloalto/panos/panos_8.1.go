@@ -16,6 +16,7 @@
 package panos
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"regexp"
@@ -35,6 +36,10 @@ type opPaloalto struct {
 	transitions map[string][]string
 	prompts     map[string][]*regexp.Regexp
 	errs        []*regexp.Regexp
+	pagers      []cli.Pager
+	confirms    []cli.Confirm
+	hints       cli.OutputHints
+	closePage   []string // commands disabling pager
 }
 
 func createOpPaloalto() cli.Operator {
@@ -57,7 +62,20 @@ func createOpPaloalto() cli.Operator {
 			regexp.MustCompile("^Validation Error:"),
 			regexp.MustCompile(`^Unknown command:\s+`),
 		},
-		lineBeak: "\n",
+		pagers: []cli.Pager{
+			{Pattern: regexp.MustCompile(`^lines \d+-\d+ ?$`), Continue: " "},
+			{Pattern: regexp.MustCompile(`\(END\) ?$`), Continue: "q"},
+		},
+		confirms: []cli.Confirm{
+			{Pattern: regexp.MustCompile(`\(y or n\) ?$`), Answer: "y", Raw: true},
+		},
+		hints: cli.OutputHints{
+			Noise: []*regexp.Regexp{
+				regexp.MustCompile(`^\[edit( .*)?\]$`),
+			},
+		},
+		closePage: []string{"set cli pager off"},
+		lineBeak:  "\n",
 	}
 }
 
@@ -84,6 +102,64 @@ func (s *opPaloalto) GetLinebreak() string {
 	return s.lineBeak
 }
 
+func (s *opPaloalto) GetModes() []string {
+	return []string{"login", "configure"}
+}
+
+func (s *opPaloalto) GetKeepaliveCommand() string {
+	// empty line
+	return ""
+}
+
+func (s *opPaloalto) GetInterrupt() string {
+	return cli.CtrlC
+}
+
+func (s *opPaloalto) GetPagers() []cli.Pager {
+	return s.pagers
+}
+
+func (s *opPaloalto) GetClosePageCommands() []string {
+	return s.closePage
+}
+
+func (s *opPaloalto) GetConfirms() []cli.Confirm {
+	return s.confirms
+}
+
+func (s *opPaloalto) GetOutputHints() cli.OutputHints {
+	return s.hints
+}
+
+func (s *opPaloalto) Escalate(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
+func (s *opPaloalto) AfterLogin(ctx context.Context, session cli.Session) error {
+	return cli.ClosePage(ctx, s, session, cli.ModeGroup(s, session))
+}
+
+func (s *opPaloalto) Setup(ctx context.Context, session cli.Session) error {
+	format := session.Request().Format
+	if format == "" {
+		return nil
+	}
+	cmd := "set cli config-output-format " + format
+	if session.Mode() != "login" {
+		// operational command in configure mode
+		cmd = "run " + cmd
+	}
+	if err := session.Send(cmd); err != nil {
+		return err
+	}
+	_, err := session.Read(ctx)
+	return err
+}
+
+func (s *opPaloalto) BeforeClose(ctx context.Context, session cli.Session) error {
+	return nil
+}
+
 func (s *opPaloalto) GetStartMode() string {
 	return "login"
 }
@@ -120,4 +196,3 @@ func (s *opPaloalto) GetSSHInitializer() cli.SSHInitializer {
 		return r, w, session, nil
 	}
 }
-
@@ -0,0 +1,100 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// Session cli session handed to operator hooks
+type Session interface {
+	Request() *protocol.CliRequest // request being served
+	Mode() string                  // current mode
+	SetMode(m string)              // record mode entered by hook
+	Prompt() string                // last prompt read
+	// Send write cmd followed by operator linebreak
+	Send(cmd string) error
+	// Read read until prompt of current mode, output hitting err patterns is an error
+	Read(ctx context.Context) (string, error)
+	// Expect read until prompt of any group, return output and name of the matched group
+	Expect(ctx context.Context, groups ...PromptGroup) (string, string, error)
+}
+
+// PromptGroup named prompt patterns
+type PromptGroup struct {
+	Name     string
+	Patterns []*regexp.Regexp
+}
+
+// Enable enter login_enable mode from login mode, enable password is sent if device asks for it
+func Enable(ctx context.Context, op Operator, s Session) error {
+	if err := s.Send("enable"); err != nil {
+		return fmt.Errorf("enter privileged mode err, %s", err)
+	}
+	_, matched, err := s.Expect(ctx,
+		PromptGroup{PromptEnablePassword, GetLoginPrompts(op, PromptEnablePassword)},
+		PromptGroup{"login_enable", op.GetPrompts("login_enable")},
+	)
+	if err != nil {
+		return fmt.Errorf("readBuff after enable err, %s", err)
+	}
+	s.SetMode("login_enable")
+	if matched == PromptEnablePassword {
+		if err := s.Send(s.Request().EnablePwd); err != nil {
+			s.SetMode("login")
+			return fmt.Errorf("enter privileged mode err, %s", err)
+		}
+		if _, err := s.Read(ctx); err != nil {
+			s.SetMode("login")
+			return fmt.Errorf("readBuff after enable err, %s", err)
+		}
+	}
+	return nil
+}
+
+// ClosePage run the operator commands disabling pager, g is the prompt back after them
+// devices refusing them are still paged on the fly
+func ClosePage(ctx context.Context, op Operator, s Session, g PromptGroup) error {
+	for _, cmd := range op.GetClosePageCommands() {
+		if err := s.Send(cmd); err != nil {
+			return err
+		}
+		ret, _, err := s.Expect(ctx, g)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(strings.NewReader(ret))
+		for scanner.Scan() {
+			if Match(op.GetErrPatterns(), scanner.Text()) {
+				logs.Notice(s.Request().LogPrefix, "close page command", cmd, "refused,", scanner.Text())
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// ModeGroup prompts of current mode of s
+func ModeGroup(op Operator, s Session) PromptGroup {
+	return PromptGroup{s.Mode(), op.GetPrompts(s.Mode())}
+}
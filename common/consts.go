@@ -22,9 +22,73 @@ const (
 	SSHConn = iota
 	// TELNETConn telnet connection
 	TELNETConn
+	// CONSOLEConn terminal server console line, reverse telnet or raw tcp
+	CONSOLEConn
 )
 
 const (
 	// DefaultTimeout default max cli execution time
 	DefaultTimeout = 5 * time.Second // seconds
 )
+
+const (
+	// TerminalType terminal type reported to telnet server
+	TerminalType = "vt100"
+	// TerminalWidth window width reported to telnet server, wide enough to avoid line wrapping
+	TerminalWidth = 2000
+	// TerminalHeight window height reported to telnet server, 0 disables paging on most devices
+	TerminalHeight = 0
+)
+
+const (
+	// AuthPassword ssh password auth
+	AuthPassword = "password"
+	// AuthKeyboardInteractive ssh keyboard-interactive auth, answer prompts with password
+	AuthKeyboardInteractive = "keyboard-interactive"
+	// AuthPublicKey ssh public key auth
+	AuthPublicKey = "publickey"
+	// AuthCertificate ssh public key auth with OpenSSH user certificate
+	AuthCertificate = "certificate"
+	// AuthAgent ssh public key auth with keys from local ssh-agent
+	AuthAgent = "agent"
+	// AuthAPIKey api transport with api key in password, no key generation
+	AuthAPIKey = "apikey"
+)
+
+const (
+	// HostKeyStrict only accept host keys already in known_hosts
+	HostKeyStrict = "strict"
+	// HostKeyTOFU trust on first use, record unknown host keys and reject changed ones
+	HostKeyTOFU = "tofu"
+	// HostKeyInsecure accept any host key
+	HostKeyInsecure = "insecure"
+)
+
+const (
+	// ProxyNone connect directly, disable global proxy for a request
+	ProxyNone = "none"
+	// ProxySOCKS5 socks5 proxy
+	ProxySOCKS5 = "socks5"
+	// ProxyHTTP http CONNECT proxy
+	ProxyHTTP = "http"
+)
+
+const (
+	// KeepaliveSSH keepalive@openssh.com global request, command keepalive is used for telnet and console
+	KeepaliveSSH = "ssh"
+	// KeepaliveCommand operator no-op command
+	KeepaliveCommand = "command"
+	// KeepaliveNone no keepalive traffic
+	KeepaliveNone = "none"
+)
+
+const (
+	// TransferSFTP file transfer over sftp subsystem
+	TransferSFTP = "sftp"
+	// TransferSCP file transfer over scp command
+	TransferSCP = "scp"
+	// TransferGet copy file from device
+	TransferGet = "get"
+	// TransferPut copy file to device
+	TransferPut = "put"
+)
@@ -30,4 +30,84 @@ const (
 	ErrCliExec = 1003
 	// ErrTimeout timeout error
 	ErrTimeout = 1005
+	// ErrAuthFailed device rejected all offered auth methods
+	ErrAuthFailed = 1006
+	// ErrHostKeyChanged device presented a host key different from the known one
+	ErrHostKeyChanged = 1007
+	// ErrHostKeyUnknown device host key unknown under strict policy
+	ErrHostKeyUnknown = 1008
+	// ErrSessionLost session lost mid-request, commands sent may have been applied
+	ErrSessionLost = 1009
+	// ErrCancelled request aborted by caller
+	ErrCancelled = 1010
+	// ErrBadRequest request can not be decoded
+	ErrBadRequest = 1011
+
+	// [2001, 3000] for utils handler
+
+	// ErrPortUnreachable port check failed
+	ErrPortUnreachable = 2001
+	// ErrProtoNotSupport port check protocol not support
+	ErrProtoNotSupport = 2002
+	// ErrPortNoResponse port gave no answer to probe, udp port open or filtered
+	ErrPortNoResponse = 2003
+	// ErrPortCheckParams port check parameters invalid
+	ErrPortCheckParams = 2004
+	// ErrPortProtoMismatch service on port does not speak the checked protocol
+	ErrPortProtoMismatch = 2005
+
+	// [3001, 4000] for admin handler
+
+	// ErrFetchHostKey fetch device host key error
+	ErrFetchHostKey = 3001
+	// ErrFingerprintMismatch presented host key does not match expected fingerprint
+	ErrFingerprintMismatch = 3002
+	// ErrSaveHostKey save host key error
+	ErrSaveHostKey = 3003
+
+	// [4001, 5000] for transfer handler
+
+	// ErrTransfer file transfer error
+	ErrTransfer = 4001
+	// ErrFileTooLarge file exceeds size limit
+	ErrFileTooLarge = 4002
+	// ErrChecksumMismatch file content does not match expected checksum
+	ErrChecksumMismatch = 4003
+	// ErrLocalPath local file path rejected
+	ErrLocalPath = 4004
+	// ErrNoTransfer no transfer running with the session
+	ErrNoTransfer = 4005
+
+	// [5001, 6000] for netconf handler
+
+	// ErrNetconfSession netconf session setup error
+	ErrNetconfSession = 5001
+	// ErrNetconfRPC rpc-reply with rpc-error
+	ErrNetconfRPC = 5002
+	// ErrNetconfOperation operation invalid or not supported by device
+	ErrNetconfOperation = 5003
+
+	// [6001, 7000] for api transport of cli handler
+
+	// ErrAPIRequest api request got no answer from device
+	ErrAPIRequest = 6001
+	// ErrAPICommand device api rejected command
+	ErrAPICommand = 6002
+	// ErrAPIJob job started by command failed on device
+	ErrAPIJob = 6003
+	// ErrAPIUnsupported device api can not run request, e.g. no structured output of command
+	ErrAPIUnsupported = 6004
+	// ErrAPIInternal device api internal error
+	ErrAPIInternal = 6005
+
+	// [7001, 8000] for snmp handler
+
+	// ErrSnmpParams snmp request parameters invalid
+	ErrSnmpParams = 7001
+	// ErrSnmpRequest agent did not answer in time or is unreachable
+	ErrSnmpRequest = 7002
+	// ErrSnmpAuth agent rejected v3 user, security level or keys
+	ErrSnmpAuth = 7003
+	// ErrSnmpError agent answered with error status
+	ErrSnmpError = 7004
 )
@@ -1,11 +1,13 @@
 module github.com/sky-cloud-tec/netd
 
-go 1.13
+go 1.14
 
 require (
+	github.com/gosnmp/gosnmp v1.32.0
+	github.com/pkg/sftp v1.11.0
 	github.com/smartystreets/goconvey v1.6.4
 	github.com/songtianyi/rrframework v0.0.0-20180901111106-4caefe307b3f
 	github.com/urfave/cli v1.22.2
-	github.com/ziutek/telnet v0.0.0-20180329124119-c3b780dc415b
 	golang.org/x/crypto v0.0.0-20191128160524-b544559bb6d1
+	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3
 )
@@ -0,0 +1,80 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ingress
+
+import (
+	"context"
+
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// AdminHandler serve netd administration like host key management
+type AdminHandler struct {
+}
+
+// AcceptHostKey replace known host keys of device with the key it presents now
+func (s *AdminHandler) AcceptHostKey(req *protocol.HostKeyRequest, res *protocol.HostKeyResponse) error {
+	logs.Info("Receiving req", req)
+
+	buildRequest(&req.Timeout, &req.LogPrefix, &req.Session, req.Address)
+
+	logs.Info(req.LogPrefix, "==========START==========")
+	defer logs.Info(req.LogPrefix, "==========END==========")
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout)
+	defer cancel()
+	fp, err := conn.AcceptHostKey(ctx, req)
+	if err != nil {
+		logs.Error(req.LogPrefix, "accept host key error,", err)
+		code := common.ErrFetchHostKey
+		if _, ok := err.(*conn.FingerprintMismatchError); ok {
+			code = common.ErrFingerprintMismatch
+		} else if fp != "" {
+			code = common.ErrSaveHostKey
+		}
+		*res = protocol.HostKeyResponse{Retcode: code, Message: err.Error(), Fingerprint: fp}
+		return nil
+	}
+	*res = protocol.HostKeyResponse{Retcode: common.OK, Message: "OK", Fingerprint: fp}
+	return nil
+}
+
+// ConnStats return cached session statistics
+func (s *AdminHandler) ConnStats(req *protocol.ConnStatsRequest, res *protocol.ConnStatsResponse) error {
+	logs.Info("Receiving req", req)
+	stats := conn.Stats()
+	if req.Address != "" {
+		var pools []protocol.PoolStats
+		for _, v := range stats {
+			if v.Address == req.Address {
+				pools = append(pools, v)
+			}
+		}
+		stats = pools
+	}
+	*res = protocol.ConnStatsResponse{Retcode: common.OK, Message: "OK", Pools: stats}
+	return nil
+}
+
+// Evict close cached sessions of device
+func (s *AdminHandler) Evict(req *protocol.EvictRequest, res *protocol.EvictResponse) error {
+	logs.Info("Receiving req", req)
+	n := conn.Evict(req.Address)
+	*res = protocol.EvictResponse{Retcode: common.OK, Message: "OK", Evicted: n}
+	return nil
+}
@@ -0,0 +1,76 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ingress
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sky-cloud-tec/netd/api"
+	_ "github.com/sky-cloud-tec/netd/api/cisco/nxos"         // load cisco nxos api
+	_ "github.com/sky-cloud-tec/netd/api/fortinet/fortigate" // load fortinet fortigate api
+	_ "github.com/sky-cloud-tec/netd/api/paloalto/panos"     // load paloalto panos api
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// doHandleAPI run commands of request through device api
+func doHandleAPI(ctx context.Context, req *protocol.CliRequest, res *protocol.CliResponse, emit conn.OutputFunc) error {
+	t := strings.Join([]string{req.Vendor, req.Type, req.Version}, ".")
+	d := api.DriverManagerInstance.Get(t)
+	if d == nil {
+		logs.Error(req.LogPrefix, "no api driver match", t)
+		*res = makeCliErrRes(common.ErrNoOpFound, "no api driver match "+t)
+		return nil
+	}
+	results, err := d.Run(ctx, req, emit)
+	if err != nil {
+		logs.Error(req.LogPrefix, "api exec error,", err)
+		code := apiErrCode(err)
+		if ctx.Err() != nil {
+			code = ctxErrCode(ctx)
+		}
+		*res = makeCliErrRes(code, "exec api cmds fail, "+err.Error())
+		res.Results = results
+		return nil
+	}
+	*res = protocol.CliResponse{
+		Retcode: common.OK,
+		Message: "OK",
+		Device:  req.Device,
+		CmdsStd: conn.CmdsStd(results),
+		Results: results,
+	}
+	return nil
+}
+
+// apiErrCode map api error to retcode
+func apiErrCode(err error) int {
+	switch e := err.(type) {
+	case *conn.AuthError, *conn.HostKeyChangedError, *conn.HostKeyUnknownError:
+		return acquireErrCode(err)
+	case *api.CommandError:
+		if e.Retcode != 0 {
+			return e.Retcode
+		}
+		return common.ErrAPICommand
+	case *api.JobError:
+		return common.ErrAPIJob
+	}
+	return common.ErrAPIRequest
+}
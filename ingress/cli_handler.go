@@ -16,6 +16,7 @@
 package ingress
 
 import (
+	"context"
 	"strings"
 	"time"
 
@@ -26,19 +27,22 @@ import (
 	_ "github.com/sky-cloud-tec/netd/cli/cisco/ios"  // load cisco switch ios
 	_ "github.com/sky-cloud-tec/netd/cli/cisco/nxos" // load cisco switch nxos
 	"github.com/sky-cloud-tec/netd/cli/conn"
-	_ "github.com/sky-cloud-tec/netd/cli/dptech/fw1000"    // load dptech fw1000
-	_ "github.com/sky-cloud-tec/netd/cli/hillstone/sg6000" // load hillstone SG6000
-	_ "github.com/sky-cloud-tec/netd/cli/huawei/usg"       // load huawei USG
-	_ "github.com/sky-cloud-tec/netd/cli/juniper/srx"      // load cisco asa
-	_ "github.com/sky-cloud-tec/netd/cli/juniper/ssg"      // load juniper ssg
-	_ "github.com/sky-cloud-tec/netd/cli/paloalto/panos"   // load paloalto panos
+	_ "github.com/sky-cloud-tec/netd/cli/dptech/fw1000"      // load dptech fw1000
 	_ "github.com/sky-cloud-tec/netd/cli/fortinet/fortigate" // load fortinet fortigate
+	_ "github.com/sky-cloud-tec/netd/cli/hillstone/sg6000"   // load hillstone SG6000
+	_ "github.com/sky-cloud-tec/netd/cli/huawei/usg"         // load huawei USG
+	_ "github.com/sky-cloud-tec/netd/cli/juniper/srx"        // load cisco asa
+	_ "github.com/sky-cloud-tec/netd/cli/juniper/ssg"        // load juniper ssg
+	_ "github.com/sky-cloud-tec/netd/cli/paloalto/panos"     // load paloalto panos
 
 	"github.com/sky-cloud-tec/netd/common"
 	"github.com/sky-cloud-tec/netd/protocol"
 	"github.com/songtianyi/rrframework/logs"
 )
 
+// cancelGrace time for interrupting device cli after request timeout
+const cancelGrace = 10 * time.Second
+
 // CliHandler run cli commands and return result to caller
 type CliHandler struct {
 }
@@ -46,41 +50,60 @@ type CliHandler struct {
 // Handle cli request
 func (s *CliHandler) Handle(req *protocol.CliRequest, res *protocol.CliResponse) error {
 	logs.Info("Receiving req", req)
-	// build timeout
-	if req.Timeout == 0 {
-		req.Timeout = common.DefaultTimeout
-	} else {
-		req.Timeout = req.Timeout * time.Second
-	}
-
-	// build log prefix
-	if req.LogPrefix == "" {
-		req.LogPrefix = "[ " + req.Device + " ]"
-	}
-
-	if req.Session == "" {
-		req.Session = rrutils.NewV4().String()
-	}
-	ch := make(chan error, 1)
+	buildCliRequest(req)
+	// cancelled on timeout, the running command is interrupted
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout)
+	defer cancel()
+	ch := make(chan protocol.CliResponse, 1)
 
 	go func() {
-		req.LogPrefix = req.LogPrefix + " [ " + req.Session + " ] "
 		logs.Info(req.LogPrefix, "==========START==========")
-		ch <- doHandle(req, res)
+		var out protocol.CliResponse
+		doHandle(ctx, req, &out, nil)
+		ch <- out
 		logs.Info(req.LogPrefix, "==========END==========")
 	}()
 
-	// timeout
+	// give doHandle a moment to clean up after cancellation
 	select {
-	case res := <-ch:
-		return res
-	case <-time.After(req.Timeout):
-		*res = makeCliErrRes(common.ErrNoOpFound, "handle req timeout")
+	case out := <-ch:
+		*res = out
+	case <-time.After(req.Timeout + cancelGrace):
+		*res = makeCliErrRes(common.ErrTimeout, "handle req timeout")
 	}
 	return nil
 }
 
-func doHandle(req *protocol.CliRequest, res *protocol.CliResponse) error {
+// buildCliRequest fill timeout, log prefix and session of request
+func buildCliRequest(req *protocol.CliRequest) {
+	buildRequest(&req.Timeout, &req.LogPrefix, &req.Session, req.Device)
+}
+
+// buildRequest fill timeout, log prefix and session fields of any request, name is logged if request sets no log prefix
+func buildRequest(timeout *time.Duration, prefix, session *string, name string) {
+	// build timeout
+	if *timeout == 0 {
+		*timeout = common.DefaultTimeout
+	} else {
+		*timeout = *timeout * time.Second
+	}
+
+	// build log prefix
+	if *prefix == "" {
+		*prefix = "[ " + name + " ]"
+	}
+
+	if *session == "" {
+		*session = rrutils.NewV4().String()
+	}
+	*prefix = *prefix + " [ " + *session + " ] "
+}
+
+// doHandle run request, output is passed to emit while it is read if emit is not nil
+func doHandle(ctx context.Context, req *protocol.CliRequest, res *protocol.CliResponse, emit conn.OutputFunc) error {
+	if strings.EqualFold(req.Protocol, "api") {
+		return doHandleAPI(ctx, req, res, emit)
+	}
 	// build device operator type
 	t := strings.Join([]string{req.Vendor, req.Type, req.Version}, ".")
 	// get operator by type
@@ -91,18 +114,30 @@ func doHandle(req *protocol.CliRequest, res *protocol.CliResponse) error {
 		return nil
 	}
 	// acquire cli connection, it could be blocked here for concurrency
-	c, err := conn.Acquire(req, op)
-	defer conn.Release(req)
+	c, err := conn.Acquire(ctx, req, op)
 	if err != nil {
 		logs.Error(req.LogPrefix, "new operator fail,", err)
-		*res = makeCliErrRes(common.ErrAcquireConn, "acquire cli conn fail, "+err.Error())
+		code := acquireErrCode(err)
+		if ctx.Err() != nil {
+			code = ctxErrCode(ctx)
+		}
+		*res = makeCliErrRes(code, "acquire cli conn fail, "+err.Error())
 		return nil
 	}
+	defer conn.Release(c)
 	// execute cli commands
-	out, err := c.Exec()
+	results, err := c.Run(ctx, emit)
 	if err != nil {
 		logs.Error(req.LogPrefix, "exec error,", err)
-		*res = makeCliErrRes(common.ErrCliExec, "exec cli cmds fail, "+err.Error())
+		code := common.ErrCliExec
+		if _, ok := err.(*conn.ConnLostError); ok {
+			code = common.ErrSessionLost
+		} else if ctx.Err() != nil {
+			code = ctxErrCode(ctx)
+		}
+		*res = makeCliErrRes(code, "exec cli cmds fail, "+err.Error())
+		// tell caller which command failed
+		res.Results = results
 		return nil
 	}
 	// make reponse
@@ -110,11 +145,33 @@ func doHandle(req *protocol.CliRequest, res *protocol.CliResponse) error {
 		Retcode: common.OK,
 		Message: "OK",
 		Device:  req.Device,
-		CmdsStd: out,
+		CmdsStd: conn.CmdsStd(results),
+		Results: results,
 	}
 	return nil
 }
 
+// acquireErrCode map acquire error to retcode
+func acquireErrCode(err error) int {
+	switch err.(type) {
+	case *conn.AuthError:
+		return common.ErrAuthFailed
+	case *conn.HostKeyChangedError:
+		return common.ErrHostKeyChanged
+	case *conn.HostKeyUnknownError:
+		return common.ErrHostKeyUnknown
+	}
+	return common.ErrAcquireConn
+}
+
+// ctxErrCode map cancellation of request to retcode
+func ctxErrCode(ctx context.Context) int {
+	if ctx.Err() == context.Canceled {
+		return common.ErrCancelled
+	}
+	return common.ErrTimeout
+}
+
 func makeCliErrRes(code int, msg string) protocol.CliResponse {
 	return protocol.CliResponse{Retcode: code, Message: msg, CmdsStd: nil}
 }
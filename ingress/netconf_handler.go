@@ -0,0 +1,78 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ingress
+
+import (
+	"context"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/netconf"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// NetconfHandler run netconf operations over ssh
+type NetconfHandler struct {
+}
+
+// Handle run operations of request in order on cached netconf session of device
+func (s *NetconfHandler) Handle(req *protocol.NetconfRequest, res *protocol.NetconfResponse) error {
+	logs.Info("Receiving req", req.Address, req.Vendor, req.Type, req.Version, len(req.Operations), "operations")
+	buildCliRequest(&req.CliRequest)
+	logs.Info(req.LogPrefix, "==========START==========")
+	defer logs.Info(req.LogPrefix, "==========END==========")
+
+	if err := netconf.Validate(req.Operations); err != nil {
+		*res = protocol.NetconfResponse{Retcode: common.ErrNetconfOperation, Message: err.Error(), Device: req.Device}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout)
+	defer cancel()
+	session, err := netconf.Acquire(ctx, &req.CliRequest)
+	if err != nil {
+		logs.Error(req.LogPrefix, "acquire netconf session error,", err)
+		code := acquireErrCode(err)
+		if code == common.ErrAcquireConn {
+			code = common.ErrNetconfSession
+		}
+		if ctx.Err() != nil {
+			code = ctxErrCode(ctx)
+		}
+		*res = protocol.NetconfResponse{Retcode: code, Message: "acquire netconf session fail, " + err.Error(), Device: req.Device}
+		return nil
+	}
+	defer netconf.Release(session)
+	results, err := session.Run(ctx, req.Operations)
+	*res = protocol.NetconfResponse{
+		Retcode:      common.OK,
+		Message:      "OK",
+		Device:       req.Device,
+		SessionID:    session.ID,
+		Capabilities: session.Capabilities,
+		Results:      results,
+	}
+	if err != nil {
+		logs.Error(req.LogPrefix, "run netconf operations error,", err)
+		res.Retcode, res.Message = common.ErrNetconfRPC, "run netconf operations fail, "+err.Error()
+		if session.IsClosed() {
+			res.Retcode = common.ErrNetconfSession
+		}
+		if ctx.Err() != nil {
+			res.Retcode = ctxErrCode(ctx)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ingress
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+const (
+	// bannerQuiet stop reading telnet banner after server is quiet for a while
+	bannerQuiet = 500 * time.Millisecond
+	// bannerMaxLines ssh servers may send other lines before version line, rfc4253
+	bannerMaxLines = 20
+	// udpBufSize large enough for any udp datagram
+	udpBufSize = 65535
+)
+
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// dialPort tcp connect to request address through proxy, deadline of check is set on returned conn
+func dialPort(req *protocol.PortCheckRequest, deadline time.Time) (net.Conn, int64, *protocol.PortCheckResponse) {
+	start := time.Now()
+	// dial through the same proxy device connections use
+	c, err := conn.Dial("tcp", net.JoinHostPort(req.IP, req.Port), req.Proxy, time.Until(deadline))
+	if err != nil {
+		logs.Info(req.LogPrefix, "port unreachable,", err)
+		res := makeUtilsErrRes(common.ErrPortUnreachable, err.Error())
+		return nil, 0, &res
+	}
+	c.SetDeadline(deadline)
+	return c, millis(time.Since(start)), nil
+}
+
+// checkTCP tcp connect only
+func checkTCP(req *protocol.PortCheckRequest, deadline time.Time) protocol.PortCheckResponse {
+	c, latency, res := dialPort(req, deadline)
+	if res != nil {
+		return *res
+	}
+	c.Close()
+	return protocol.PortCheckResponse{Retcode: common.OK, Message: "OK", Latency: latency, Detail: "tcp connected"}
+}
+
+// checkUDP send payload and wait for any answer, udp is not proxied
+func checkUDP(req *protocol.PortCheckRequest, deadline time.Time) protocol.PortCheckResponse {
+	payload, err := hex.DecodeString(req.Payload)
+	if err != nil {
+		return makeUtilsErrRes(common.ErrPortCheckParams, "invalid hex payload, "+err.Error())
+	}
+	c, err := net.DialTimeout("udp", net.JoinHostPort(req.IP, req.Port), time.Until(deadline))
+	if err != nil {
+		return makeUtilsErrRes(common.ErrPortUnreachable, err.Error())
+	}
+	defer c.Close()
+	c.SetDeadline(deadline)
+	start := time.Now()
+	if _, err := c.Write(payload); err != nil {
+		return makeUtilsErrRes(common.ErrPortUnreachable, err.Error())
+	}
+	buf := make([]byte, udpBufSize)
+	n, err := c.Read(buf)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			logs.Info(req.LogPrefix, "no udp response,", err)
+			return makeUtilsErrRes(common.ErrPortNoResponse, "no response, port open or filtered")
+		}
+		// icmp port unreachable is reported as connection refused
+		logs.Info(req.LogPrefix, "port unreachable,", err)
+		return makeUtilsErrRes(common.ErrPortUnreachable, err.Error())
+	}
+	return protocol.PortCheckResponse{
+		Retcode:  common.OK,
+		Message:  "OK",
+		Latency:  millis(time.Since(start)),
+		Detail:   fmt.Sprintf("%d bytes response", n),
+		Response: hex.EncodeToString(buf[:n]),
+	}
+}
+
+// checkSSH connect and read ssh version line
+func checkSSH(req *protocol.PortCheckRequest, deadline time.Time) protocol.PortCheckResponse {
+	c, latency, res := dialPort(req, deadline)
+	if res != nil {
+		return *res
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+	var first string
+	for i := 0; i < bannerMaxLines; i++ {
+		line, err := r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if first == "" {
+			first = line
+		}
+		if strings.HasPrefix(line, "SSH-") {
+			return protocol.PortCheckResponse{Retcode: common.OK, Message: "OK", Latency: latency, Detail: line, Banner: line}
+		}
+		if err != nil {
+			if first == "" {
+				return protocol.PortCheckResponse{Retcode: common.ErrPortNoResponse, Message: "no ssh banner, " + err.Error(), Latency: latency}
+			}
+			break
+		}
+	}
+	return protocol.PortCheckResponse{Retcode: common.ErrPortProtoMismatch, Message: "not ssh service", Latency: latency, Banner: first}
+}
+
+// checkTelnet connect, negotiate options and read text server sent first
+func checkTelnet(req *protocol.PortCheckRequest, deadline time.Time) protocol.PortCheckResponse {
+	c, latency, res := dialPort(req, deadline)
+	if res != nil {
+		return *res
+	}
+	defer c.Close()
+	tc := conn.NewTelnetConn(c)
+	var text []byte
+	buf := make([]byte, 4096)
+	for {
+		d := deadline
+		if len(text) > 0 && time.Now().Add(bannerQuiet).Before(d) {
+			d = time.Now().Add(bannerQuiet)
+		}
+		tc.SetReadDeadline(d)
+		n, err := tc.Read(buf)
+		text = append(text, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	banner := ""
+	for _, line := range strings.Split(string(text), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			banner = line
+			break
+		}
+	}
+	if banner == "" {
+		return protocol.PortCheckResponse{Retcode: common.ErrPortNoResponse, Message: "no telnet banner", Latency: latency}
+	}
+	if strings.HasPrefix(banner, "SSH-") {
+		return protocol.PortCheckResponse{Retcode: common.ErrPortProtoMismatch, Message: "not telnet service", Latency: latency, Banner: banner}
+	}
+	return protocol.PortCheckResponse{Retcode: common.OK, Message: "OK", Latency: latency, Detail: banner, Banner: banner}
+}
+
+// checkTLS handshake without verification, then verify chain separately so expired or self signed certificates are still inspected
+func checkTLS(req *protocol.PortCheckRequest, deadline time.Time) protocol.PortCheckResponse {
+	c, latency, res := dialPort(req, deadline)
+	if res != nil {
+		return *res
+	}
+	defer c.Close()
+	name := req.ServerName
+	if name == "" {
+		name = req.IP
+	}
+	start := time.Now()
+	tc := tls.Client(c, &tls.Config{ServerName: name, InsecureSkipVerify: true})
+	if err := tc.Handshake(); err != nil {
+		logs.Info(req.LogPrefix, "tls handshake fail,", err)
+		code := common.ErrPortProtoMismatch
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			code = common.ErrPortNoResponse
+		}
+		return protocol.PortCheckResponse{Retcode: code, Message: "tls handshake fail, " + err.Error(), Latency: latency}
+	}
+	state := tc.ConnectionState()
+	info := &protocol.TLSInfo{
+		Version:     tlsVersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		Handshake:   millis(time.Since(start)),
+	}
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		now := time.Now()
+		info.Subject = leaf.Subject.String()
+		info.Issuer = leaf.Issuer.String()
+		info.DNSNames = leaf.DNSNames
+		info.NotBefore = leaf.NotBefore
+		info.NotAfter = leaf.NotAfter
+		info.Expired = now.After(leaf.NotAfter)
+		info.DaysLeft = int(leaf.NotAfter.Sub(now).Hours() / 24)
+		opts := x509.VerifyOptions{DNSName: name, Intermediates: x509.NewCertPool(), CurrentTime: now}
+		for _, cert := range state.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := leaf.Verify(opts); err != nil {
+			info.VerifyError = err.Error()
+		} else {
+			info.Verified = true
+		}
+	}
+	detail := fmt.Sprintf("%s %s, subject %s, expires %s, %d days left", info.Version, info.CipherSuite, info.Subject, info.NotAfter.Format(time.RFC3339), info.DaysLeft)
+	return protocol.PortCheckResponse{Retcode: common.OK, Message: "OK", Latency: latency, Detail: detail, TLS: info}
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
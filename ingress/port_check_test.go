@@ -0,0 +1,163 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ingress
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// serveOnce accept one connection and write greeting to it
+func serveOnce(greeting string) (string, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.Write([]byte(greeting))
+		time.Sleep(time.Second)
+		c.Close()
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	return host, port
+}
+
+func checkPort(req *protocol.PortCheckRequest) protocol.PortCheckResponse {
+	var res protocol.PortCheckResponse
+	So(new(UtilsHandler).CheckPort(req, &res), ShouldBeNil)
+	return res
+}
+
+func TestUtilsHandler_CheckPort(t *testing.T) {
+
+	Convey("check tcp port", t, func() {
+		host, port := serveOnce("")
+		res := checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Timeout: 2})
+		So(res.Retcode, ShouldEqual, common.OK)
+		So(res.Detail, ShouldEqual, "tcp connected")
+
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		_, closed, _ := net.SplitHostPort(l.Addr().String())
+		l.Close()
+		res = checkPort(&protocol.PortCheckRequest{IP: host, Port: closed, Timeout: 2})
+		So(res.Retcode, ShouldEqual, common.ErrPortUnreachable)
+
+		res = checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "sctp", Timeout: 2})
+		So(res.Retcode, ShouldEqual, common.ErrProtoNotSupport)
+	})
+
+	Convey("probe udp port", t, func() {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer pc.Close()
+		go func() {
+			buf := make([]byte, 512)
+			for {
+				n, addr, err := pc.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				// answer pings only
+				if string(buf[:n]) == "ping" {
+					pc.WriteTo([]byte("pong"), addr)
+				}
+			}
+		}()
+		host, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+
+		res := checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "udp", Payload: "70696e67", Timeout: 2})
+		So(res.Retcode, ShouldEqual, common.OK)
+		So(res.Response, ShouldEqual, "706f6e67")
+
+		res = checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "udp", Payload: "00", Timeout: 1})
+		So(res.Retcode, ShouldEqual, common.ErrPortNoResponse)
+
+		res = checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "udp", Payload: "zz", Timeout: 1})
+		So(res.Retcode, ShouldEqual, common.ErrPortCheckParams)
+	})
+
+	Convey("grab ssh banner", t, func() {
+		host, port := serveOnce("SSH-2.0-OpenSSH_8.0\r\n")
+		res := checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "ssh", Timeout: 2})
+		So(res.Retcode, ShouldEqual, common.OK)
+		So(res.Banner, ShouldEqual, "SSH-2.0-OpenSSH_8.0")
+
+		host, port = serveOnce("220 ftp ready\r\n")
+		res = checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "ssh", Timeout: 2})
+		So(res.Retcode, ShouldEqual, common.ErrPortProtoMismatch)
+		So(res.Banner, ShouldEqual, "220 ftp ready")
+	})
+
+	Convey("grab telnet banner", t, func() {
+		// IAC WILL ECHO, IAC DO TTYPE before text
+		host, port := serveOnce("\xff\xfb\x01\xff\xfd\x18\r\nUser Access Verification\r\n\r\nUsername: ")
+		res := checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "telnet", Timeout: 2})
+		So(res.Retcode, ShouldEqual, common.OK)
+		So(res.Banner, ShouldEqual, "User Access Verification")
+
+		host, port = serveOnce("")
+		res = checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "telnet", Timeout: 1})
+		So(res.Retcode, ShouldEqual, common.ErrPortNoResponse)
+	})
+
+	Convey("inspect tls certificate", t, func() {
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer ts.Close()
+		host, port, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "https://"))
+		res := checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "tls", Timeout: 2})
+		So(res.Retcode, ShouldEqual, common.OK)
+		So(res.TLS, ShouldNotBeNil)
+		So(res.TLS.Subject, ShouldContainSubstring, "Acme Co")
+		So(res.TLS.DNSNames, ShouldContain, "example.com")
+		So(res.TLS.Expired, ShouldBeFalse)
+		So(res.TLS.DaysLeft, ShouldBeGreaterThan, 0)
+		// self signed test certificate is not trusted by system roots
+		So(res.TLS.Verified, ShouldBeFalse)
+		So(res.TLS.VerifyError, ShouldNotBeEmpty)
+
+		host, port = serveOnce("SSH-2.0-OpenSSH_8.0\r\n")
+		res = checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "tls", Timeout: 2})
+		So(res.Retcode, ShouldEqual, common.ErrPortProtoMismatch)
+
+		// connect and handshake share one timeout
+		silent, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer silent.Close()
+		go func() {
+			if c, err := silent.Accept(); err == nil {
+				defer c.Close()
+				time.Sleep(3 * time.Second)
+			}
+		}()
+		host, port, _ = net.SplitHostPort(silent.Addr().String())
+		start := time.Now()
+		res = checkPort(&protocol.PortCheckRequest{IP: host, Port: port, Proto: "tls", Timeout: 1})
+		So(res.Retcode, ShouldEqual, common.ErrPortNoResponse)
+		So(time.Since(start), ShouldBeLessThan, 1500*time.Millisecond)
+	})
+}
@@ -0,0 +1,66 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ingress
+
+import (
+	"context"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/sky-cloud-tec/netd/snmp"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// SnmpHandler query devices over snmp v2c and v3
+type SnmpHandler struct {
+}
+
+// Handle run get, getnext, walk or bulkwalk of request
+func (s *SnmpHandler) Handle(req *protocol.SnmpRequest, res *protocol.SnmpResponse) error {
+	logs.Info("Receiving req", req.Address, req.Version, req.Operation, req.OIDs)
+	buildRequest(&req.Timeout, &req.LogPrefix, &req.Session, req.Device)
+	logs.Info(req.LogPrefix, "==========START==========")
+	defer logs.Info(req.LogPrefix, "==========END==========")
+
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout)
+	defer cancel()
+	varbinds, err := snmp.Run(ctx, req)
+	if err != nil {
+		logs.Error(req.LogPrefix, "snmp", req.Operation, "error,", err)
+		code := snmpErrCode(err)
+		if ctx.Err() != nil {
+			code = ctxErrCode(ctx)
+		}
+		*res = protocol.SnmpResponse{Retcode: code, Message: "snmp " + req.Operation + " fail, " + err.Error(), Device: req.Device}
+		return nil
+	}
+	logs.Info(req.LogPrefix, len(varbinds), "varbinds")
+	*res = protocol.SnmpResponse{Retcode: common.OK, Message: "OK", Device: req.Device, Varbinds: varbinds}
+	return nil
+}
+
+// snmpErrCode map snmp error to retcode
+func snmpErrCode(err error) int {
+	switch err.(type) {
+	case *snmp.ParamsError:
+		return common.ErrSnmpParams
+	case *snmp.AuthError:
+		return common.ErrSnmpAuth
+	case *snmp.StatusError:
+		return common.ErrSnmpError
+	}
+	return common.ErrSnmpRequest
+}
@@ -0,0 +1,151 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ingress
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// Stream serve cli requests over tcp, streaming output while commands run
+// each connection carries one CliRequest json line, StreamFrame json lines are written back
+type Stream struct {
+	addr string
+}
+
+// NewStream return created stream instance
+func NewStream(addr string) (*Stream, error) {
+	return &Stream{addr: addr}, nil
+}
+
+// Serve start listen tcp port and accept stream requests
+func (s *Stream) Serve() error {
+	listener, e := net.Listen("tcp", s.addr)
+	if e != nil {
+		return e
+	}
+	return s.serve(listener)
+}
+
+// serve accept stream requests from l till it fails, temporary errors are retried with backoff like net/http does
+func (s *Stream) serve(l net.Listener) error {
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay *= 2
+				}
+				if delay > time.Second {
+					delay = time.Second
+				}
+				logs.Error("accept error: "+err.Error()+", retrying in", delay)
+				time.Sleep(delay)
+				continue
+			}
+			// closed listener included
+			logs.Error("accept error: " + err.Error())
+			return err
+		}
+		delay = 0
+		logs.Info("new stream connection established")
+		go serveStream(conn)
+	}
+}
+
+func serveStream(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	req := &protocol.CliRequest{}
+	line, err := r.ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, req)
+	}
+	if err != nil {
+		logs.Error("decode stream request error,", err)
+		json.NewEncoder(c).Encode(&protocol.StreamFrame{Type: protocol.StreamStatus, Retcode: common.ErrBadRequest, Message: "decode request fail, " + err.Error()})
+		return
+	}
+	logs.Info("Receiving stream req", req)
+	buildCliRequest(req)
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout)
+	defer cancel()
+
+	// a cancel frame or dropped connection aborts the request
+	// plain eof is a half close of caller done sending, the request keeps running
+	go func() {
+		for {
+			line, err := r.ReadBytes('\n')
+			var f protocol.StreamFrame
+			if len(line) > 0 && json.Unmarshal(line, &f) == nil && f.Type == protocol.StreamCancel {
+				logs.Info(req.LogPrefix, "cancelled by caller")
+				cancel()
+				return
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					logs.Info(req.LogPrefix, "caller disconnected,", err)
+				}
+				cancel()
+				return
+			}
+		}
+	}()
+
+	var (
+		mu  sync.Mutex
+		enc = json.NewEncoder(c)
+	)
+	write := func(f *protocol.StreamFrame) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(f); err != nil {
+			logs.Error(req.LogPrefix, "write stream frame error,", err)
+			// caller is gone
+			cancel()
+		}
+	}
+
+	logs.Info(req.LogPrefix, "==========START==========")
+	defer logs.Info(req.LogPrefix, "==========END==========")
+	var res protocol.CliResponse
+	doHandle(ctx, req, &res, func(index int, cmd, data string) {
+		write(&protocol.StreamFrame{Type: protocol.StreamOutput, Index: index, Command: cmd, Data: data})
+	})
+	write(&protocol.StreamFrame{
+		Type:    protocol.StreamStatus,
+		Retcode: res.Retcode,
+		Message: res.Message,
+		Device:  res.Device,
+		CmdsStd: res.CmdsStd,
+		Results: res.Results,
+	})
+}
@@ -0,0 +1,152 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ingress
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// streamRequest send request to serveStream, run after on the caller connection, return final frame
+func streamRequest(req *protocol.CliRequest, after func(c *net.TCPConn)) protocol.StreamFrame {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			serveStream(c)
+		}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	So(err, ShouldBeNil)
+	defer c.Close()
+	So(json.NewEncoder(c).Encode(req), ShouldBeNil)
+	after(c.(*net.TCPConn))
+	var f protocol.StreamFrame
+	r := bufio.NewReader(c)
+	for f.Type != protocol.StreamStatus {
+		line, err := r.ReadBytes('\n')
+		So(err, ShouldBeNil)
+		So(json.Unmarshal(line, &f), ShouldBeNil)
+	}
+	return f
+}
+
+func TestServeStream(t *testing.T) {
+
+	Convey("stream request abort", t, func() {
+		// device accepting connections but never answering
+		dev, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer dev.Close()
+		go func() {
+			for {
+				c, err := dev.Accept()
+				if err != nil {
+					return
+				}
+				defer c.Close()
+			}
+		}()
+		req := &protocol.CliRequest{
+			Device:   "stream-test",
+			Vendor:   "cisco",
+			Type:     "asa",
+			Version:  "9.6",
+			Address:  dev.Addr().String(),
+			Protocol: "telnet",
+			Auth:     protocol.Auth{Username: "admin", Password: "r00tme"},
+			Commands: []string{"show run"},
+			Mode:     "login",
+			Timeout:  1,
+		}
+
+		Convey("half close keeps request running", func() {
+			start := time.Now()
+			f := streamRequest(req, func(c *net.TCPConn) { c.CloseWrite() })
+			So(f.Retcode, ShouldEqual, common.ErrTimeout)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, time.Second)
+		})
+
+		Convey("cancel frame aborts request", func() {
+			f := streamRequest(req, func(c *net.TCPConn) {
+				json.NewEncoder(c).Encode(&protocol.StreamFrame{Type: protocol.StreamCancel})
+			})
+			So(f.Retcode, ShouldEqual, common.ErrCancelled)
+		})
+	})
+}
+
+// flakyListener fail accept with temporary errors first, then hand out conns, then report closed
+type flakyListener struct {
+	net.Listener
+	temporary int
+	conns     chan net.Conn
+	accepted  int
+}
+
+type tempError struct{}
+
+func (tempError) Error() string   { return "too many open files" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.temporary > 0 {
+		l.temporary--
+		return nil, tempError{}
+	}
+	c, ok := <-l.conns
+	if !ok {
+		return nil, errors.New("use of closed network connection")
+	}
+	l.accepted++
+	return c, nil
+}
+
+func TestStreamServe(t *testing.T) {
+
+	Convey("stream accept loop", t, func() {
+		l := &flakyListener{temporary: 3, conns: make(chan net.Conn, 1)}
+		c1, c2 := net.Pipe()
+		defer c2.Close()
+		l.conns <- c1
+		close(l.conns)
+		done := make(chan error, 1)
+		go func() {
+			done <- (&Stream{}).serve(l)
+		}()
+		select {
+		case err := <-done:
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "closed")
+		case <-time.After(time.Second):
+			So("serve not returned", ShouldBeEmpty)
+		}
+		So(l.temporary, ShouldEqual, 0)
+		So(l.accepted, ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,122 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ingress
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// defaultTransferTimeout transfer time if request sets none, images take minutes
+const defaultTransferTimeout = 10 * time.Minute
+
+// TransferHandler copy files between netd host and devices over ssh
+type TransferHandler struct {
+}
+
+// transfers progress of running transfers by session
+var transfers sync.Map
+
+type transferProgress struct {
+	done  int64
+	total int64
+}
+
+func (p *transferProgress) update(done, total int64) {
+	atomic.StoreInt64(&p.done, done)
+	atomic.StoreInt64(&p.total, total)
+}
+
+// Transfer get file from device or put file to device
+func (s *TransferHandler) Transfer(req *protocol.TransferRequest, res *protocol.TransferResponse) error {
+	logs.Info("Receiving req", req.Address, req.Method, req.Direction, req.Remote, req.Local)
+	noTimeout := req.Timeout == 0
+	buildCliRequest(&req.CliRequest)
+	if noTimeout {
+		req.Timeout = defaultTransferTimeout
+	}
+	logs.Info(req.LogPrefix, "==========START==========")
+	defer logs.Info(req.LogPrefix, "==========END==========")
+
+	p := &transferProgress{total: -1}
+	transfers.Store(req.Session, p)
+	defer transfers.Delete(req.Session)
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout)
+	defer cancel()
+	start := time.Now()
+	out, err := conn.Transfer(ctx, req, p.update)
+	if err != nil {
+		logs.Error(req.LogPrefix, "transfer error,", err)
+		code := transferErrCode(err)
+		if ctx.Err() != nil {
+			code = ctxErrCode(ctx)
+		}
+		*res = protocol.TransferResponse{Retcode: code, Message: "transfer fail, " + err.Error(), Device: req.Device, Session: req.Session}
+		return nil
+	}
+	logs.Info(req.LogPrefix, "transferred", out.Size, "bytes, sha256", out.Checksum)
+	*res = protocol.TransferResponse{
+		Retcode:  common.OK,
+		Message:  "OK",
+		Device:   req.Device,
+		Session:  req.Session,
+		Size:     out.Size,
+		Checksum: out.Checksum,
+		Duration: time.Since(start).Nanoseconds() / int64(time.Millisecond),
+		Content:  out.Content,
+	}
+	return nil
+}
+
+// Progress return bytes transferred by running transfer of session
+// callers polling progress set session in the transfer request, a generated one is only known from the response
+func (s *TransferHandler) Progress(req *protocol.TransferProgressRequest, res *protocol.TransferProgressResponse) error {
+	v, ok := transfers.Load(req.Session)
+	if !ok {
+		*res = protocol.TransferProgressResponse{Retcode: common.ErrNoTransfer, Message: "no transfer of session " + req.Session}
+		return nil
+	}
+	p := v.(*transferProgress)
+	*res = protocol.TransferProgressResponse{
+		Retcode: common.OK,
+		Message: "OK",
+		Done:    atomic.LoadInt64(&p.done),
+		Total:   atomic.LoadInt64(&p.total),
+	}
+	return nil
+}
+
+// transferErrCode map transfer error to retcode
+func transferErrCode(err error) int {
+	switch err.(type) {
+	case *conn.TooLargeError:
+		return common.ErrFileTooLarge
+	case *conn.ChecksumError:
+		return common.ErrChecksumMismatch
+	case *conn.LocalPathError:
+		return common.ErrLocalPath
+	case *conn.AuthError, *conn.HostKeyChangedError, *conn.HostKeyUnknownError:
+		return acquireErrCode(err)
+	}
+	return common.ErrTransfer
+}
@@ -16,58 +16,63 @@
 package ingress
 
 import (
+	"strings"
 	"time"
 
 	"github.com/sky-cloud-tec/netd/common"
 	"github.com/sky-cloud-tec/netd/protocol"
 	"github.com/songtianyi/rrframework/logs"
-	"github.com/songtianyi/rrframework/utils"
 )
 
+// checkGrace time allowed beyond req timeout before giving up on a check
+const checkGrace = time.Second
+
 // UtilsHandler serve many useful services like port checking
 type UtilsHandler struct {
 }
 
-// CheckPort check specifed port is open or not
+// CheckPort check specifed port is open or not, and inspect ssh, telnet or tls service on it
 func (s *UtilsHandler) CheckPort(req *protocol.PortCheckRequest, res *protocol.PortCheckResponse) error {
 	logs.Info("Receiving req", req)
 
-	// build timeout
-	if req.Timeout == 0 {
-		req.Timeout = common.DefaultTimeout
-	} else {
-		req.Timeout = req.Timeout * time.Second
-	}
+	buildRequest(&req.Timeout, &req.LogPrefix, &req.Session, req.Proto+"://"+req.IP+":"+req.Port)
 
-	// build log prefix
-	if req.LogPrefix == "" {
-		req.LogPrefix = "[ " + req.Proto + "://" + req.IP + ":" + req.Port + " ]"
-	}
-
-	// build session
-	if req.Session == "" {
-		req.Session = rrutils.NewV4().String()
-	}
-	req.LogPrefix = req.LogPrefix + " [ " + req.Session + " ] "
-
-	ch := make(chan error, 1)
+	// one deadline covers connect and what follows it
+	deadline := time.Now().Add(req.Timeout)
+	ch := make(chan protocol.PortCheckResponse, 1)
 
 	go func() {
 		logs.Info(req.LogPrefix, "==========START==========")
+		ch <- doCheckPort(req, deadline)
 		logs.Info(req.LogPrefix, "==========END==========")
 	}()
 
-	// timeout
+	// every check is bounded by req timeout, the grace covers slow dns and proxies
 	select {
-	case res := <-ch:
-		return res
-	case <-time.After(req.Timeout):
-		*res = makeUtilsErrRes(common.ErrNoOpFound, "handle req timeout")
+	case *res = <-ch:
+	case <-time.After(time.Until(deadline) + checkGrace):
+		*res = makeUtilsErrRes(common.ErrTimeout, "handle req timeout")
 	}
 
 	return nil
 }
 
+func doCheckPort(req *protocol.PortCheckRequest, deadline time.Time) protocol.PortCheckResponse {
+	switch strings.ToLower(req.Proto) {
+	case "", "tcp":
+		return checkTCP(req, deadline)
+	case "udp":
+		return checkUDP(req, deadline)
+	case "ssh":
+		return checkSSH(req, deadline)
+	case "telnet":
+		return checkTelnet(req, deadline)
+	case "tls":
+		return checkTLS(req, deadline)
+	}
+	return makeUtilsErrRes(common.ErrProtoNotSupport, "proto "+req.Proto+" not support")
+}
+
 func makeUtilsErrRes(code int, msg string) protocol.PortCheckResponse {
 	return protocol.PortCheckResponse{Retcode: code, Message: msg}
 }
@@ -0,0 +1,180 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package netconf
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+var (
+	// idleTimeout idle session is closed after it
+	idleTimeout = 5 * time.Minute
+	// unlockTimeout bounds unlocking datastores left locked when session is released
+	unlockTimeout = 10 * time.Second
+)
+
+// entry cached session of one device, account and context
+type entry struct {
+	sema    chan struct{} // requests of same session run one at a time
+	session *Session
+	idle    *time.Timer
+}
+
+// Manager owns netconf sessions of devices, it is safe for concurrent use
+type Manager struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	closed  bool
+}
+
+// NewManager create a netconf session manager
+func NewManager() *Manager {
+	return &Manager{entries: make(map[string]*entry)}
+}
+
+// manager used by package level functions
+var manager = NewManager()
+
+// Acquire lease netconf session from default manager
+func Acquire(ctx context.Context, req *protocol.CliRequest) (*Session, error) {
+	return manager.Acquire(ctx, req)
+}
+
+// Release return netconf session to default manager
+func Release(s *Session) {
+	manager.Release(s)
+}
+
+// CloseAll close every session of default manager
+func CloseAll() {
+	manager.CloseAll()
+}
+
+// Acquire lease cached session of device, it is dialed if there is none or cached one is closed
+// it is blocked while session is leased by other request, till ctx is cancelled
+func (m *Manager) Acquire(ctx context.Context, req *protocol.CliRequest) (*Session, error) {
+	req.Protocol = "netconf"
+	key := conn.SessionKey(req)
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("netconf manager closed")
+	}
+	e, ok := m.entries[key]
+	if !ok {
+		e = &entry{sema: make(chan struct{}, 1)}
+		m.entries[key] = e
+	}
+	m.mu.Unlock()
+
+	select {
+	case e.sema <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if e.idle != nil {
+		e.idle.Stop()
+		e.idle = nil
+	}
+	if e.session != nil && !e.session.IsClosed() {
+		logs.Info(req.LogPrefix, "reuse netconf session", e.session.ID)
+		return e.session, nil
+	}
+	s, err := Dial(ctx, req)
+	if err != nil {
+		e.session = nil
+		<-e.sema
+		return nil, err
+	}
+	s.key = key
+	e.session = s
+	logs.Info(req.LogPrefix, "netconf session", s.ID, "established")
+	return s, nil
+}
+
+// Release return session, closed one is dropped and idle one is closed after idleTimeout
+// datastores left locked are unlocked first, session failing that is closed so that the device drops its locks
+func (m *Manager) Release(s *Session) {
+	m.mu.Lock()
+	e, ok := m.entries[s.key]
+	closed := m.closed
+	m.mu.Unlock()
+	if !ok || e.session != s {
+		s.Close()
+		return
+	}
+	switch {
+	case closed:
+		s.Close()
+		e.session = nil
+	case s.IsClosed():
+		e.session = nil
+	case !releaseLocks(s):
+		s.Close()
+		e.session = nil
+	default:
+		e.idle = time.AfterFunc(idleTimeout, func() { m.expire(e, s) })
+	}
+	<-e.sema
+}
+
+// expire close idle session if it is not leased again
+func (m *Manager) expire(e *entry, s *Session) {
+	select {
+	case e.sema <- struct{}{}:
+	default:
+		return
+	}
+	if e.session == s {
+		logs.Info("closing idle netconf session", s.ID)
+		s.Close()
+		e.session = nil
+	}
+	<-e.sema
+}
+
+// CloseAll close idle sessions and refuse new requests, leased sessions are closed when released
+func (m *Manager) CloseAll() {
+	m.mu.Lock()
+	m.closed = true
+	entries := m.entries
+	m.mu.Unlock()
+	for _, e := range entries {
+		select {
+		case e.sema <- struct{}{}:
+			if e.session != nil {
+				e.session.Close()
+				e.session = nil
+			}
+			<-e.sema
+		default:
+		}
+	}
+}
+
+// releaseLocks unlock datastores left locked by session within unlockTimeout
+func releaseLocks(s *Session) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+	return s.unlockAll(ctx)
+}
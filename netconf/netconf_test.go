@@ -0,0 +1,412 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package netconf
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/common"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeDevice ssh server with netconf subsystem, it keeps running and candidate datastores
+type fakeDevice struct {
+	l      net.Listener
+	config *ssh.ServerConfig
+	base11 bool // announce base 1.1
+	caps   []string
+
+	mu        sync.Mutex
+	sessions  int
+	running   string
+	candidate string
+	lockedBy  int // session holding candidate lock, 0 if none
+}
+
+func newFakeDevice(base11 bool, caps ...string) *fakeDevice {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "admin" && string(pass) == "r00tme" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	d := &fakeDevice{l: l, config: config, base11: base11, caps: caps, running: "<hostname>r1</hostname>"}
+	d.candidate = d.running
+	go d.serve()
+	return d
+}
+
+func (d *fakeDevice) serve() {
+	for {
+		c, err := d.l.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(c)
+	}
+}
+
+func (d *fakeDevice) handle(c net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(c, d.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer ch.Close()
+			for r := range reqs {
+				if r.Type != "subsystem" || string(r.Payload[4:]) != "netconf" {
+					r.Reply(false, nil)
+					continue
+				}
+				r.Reply(true, nil)
+				d.netconf(&Session{r: bufio.NewReader(ch), w: ch})
+				return
+			}
+		}()
+	}
+}
+
+// netconf serve one session, framing of Session is reused
+func (d *fakeDevice) netconf(s *Session) {
+	d.mu.Lock()
+	d.sessions++
+	id := d.sessions
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		if d.lockedBy == id {
+			d.lockedBy = 0
+		}
+		d.mu.Unlock()
+	}()
+
+	caps := append([]string{capBase10}, d.caps...)
+	if d.base11 {
+		caps = append(caps, capBase11)
+	}
+	b, _ := xml.Marshal(&hello{Capabilities: caps, SessionID: strconv.Itoa(id)})
+	if s.write(b) != nil {
+		return
+	}
+	msg, err := s.read()
+	if err != nil {
+		return
+	}
+	// namespace checked like strict devices do
+	var h struct {
+		XMLName      xml.Name
+		Capabilities []string `xml:"capabilities>capability"`
+	}
+	if xml.Unmarshal(msg, &h) != nil || h.XMLName.Space != nsBase || h.XMLName.Local != "hello" {
+		return
+	}
+	s.Capabilities = h.Capabilities
+	s.chunked = d.base11 && s.HasCapability(capBase11)
+	for {
+		msg, err := s.read()
+		if err != nil {
+			return
+		}
+		var rpc struct {
+			MessageID string `xml:"message-id,attr"`
+			Op        struct {
+				XMLName xml.Name
+				Inner   string `xml:",innerxml"`
+			} `xml:",any"`
+		}
+		if err := xml.Unmarshal(msg, &rpc); err != nil {
+			return
+		}
+		body := d.rpc(id, rpc.Op.XMLName.Local, rpc.Op.Inner)
+		reply := `<rpc-reply message-id="` + rpc.MessageID + `" xmlns="` + nsBase + `">` + body + `</rpc-reply>`
+		if s.write([]byte(reply)) != nil || rpc.Op.XMLName.Local == "close-session" {
+			return
+		}
+	}
+}
+
+func rpcError(tag, msg string) string {
+	return "<rpc-error><error-type>protocol</error-type><error-tag>" + tag +
+		"</error-tag><error-severity>error</error-severity><error-message>" + msg + "</error-message></rpc-error>"
+}
+
+func (d *fakeDevice) rpc(id int, op, inner string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch op {
+	case "get-config":
+		if strings.Contains(inner, "<candidate/>") {
+			return "<data>" + d.candidate + "</data>"
+		}
+		return "<data>" + d.running + "</data>"
+	case "edit-config":
+		if d.lockedBy != 0 && d.lockedBy != id {
+			return rpcError("in-use", "candidate is locked")
+		}
+		var edit struct {
+			Config struct {
+				Inner string `xml:",innerxml"`
+			} `xml:"config"`
+		}
+		xml.Unmarshal([]byte("<edit-config>"+inner+"</edit-config>"), &edit)
+		if strings.Contains(edit.Config.Inner, "<bad") {
+			return rpcError("invalid-value", "bad element")
+		}
+		d.candidate = edit.Config.Inner
+	case "lock":
+		if d.lockedBy != 0 {
+			return rpcError("lock-denied", "locked by session "+strconv.Itoa(d.lockedBy))
+		}
+		d.lockedBy = id
+	case "unlock":
+		if d.lockedBy != id {
+			return rpcError("operation-failed", "not locked by this session")
+		}
+		d.lockedBy = 0
+	case "commit":
+		d.running = d.candidate
+	case "discard-changes":
+		d.candidate = d.running
+	case "close-session":
+	default:
+		return rpcError("operation-not-supported", op)
+	}
+	return "<ok/>"
+}
+
+func (d *fakeDevice) state() (running, candidate string, lockedBy int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.running, d.candidate, d.lockedBy
+}
+
+func (d *fakeDevice) Close() error {
+	return d.l.Close()
+}
+
+func (d *fakeDevice) request() *protocol.CliRequest {
+	return &protocol.CliRequest{
+		Address:       d.l.Addr().String(),
+		Auth:          protocol.Auth{Username: "admin", Password: "r00tme"},
+		HostKeyPolicy: common.HostKeyInsecure,
+		Timeout:       5 * time.Second,
+		LogPrefix:     "[ test ]",
+	}
+}
+
+func TestNetconf(t *testing.T) {
+
+	Convey("netconf session", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		Convey("base 1.1 framing and operations", func() {
+			d := newFakeDevice(true)
+			defer d.Close()
+			s, err := Dial(ctx, d.request())
+			So(err, ShouldBeNil)
+			defer s.Close()
+			So(s.ID, ShouldEqual, "1")
+			So(s.chunked, ShouldBeTrue)
+			So(s.HasCapability(capBase11), ShouldBeTrue)
+
+			res, err := s.Run(ctx, []protocol.NetconfOperation{
+				{Type: OpLock},
+				{Type: OpEditConfig, Config: "<hostname>r2</hostname>"},
+				{Type: OpCommit},
+				{Type: OpUnlock},
+				{Type: OpGetConfig},
+			})
+			So(err, ShouldBeNil)
+			So(len(res), ShouldEqual, 5)
+			for _, r := range res {
+				So(r.Status, ShouldEqual, protocol.CmdOK)
+			}
+			So(res[4].Reply, ShouldEqual, "<data><hostname>r2</hostname></data>")
+			running, _, lockedBy := d.state()
+			So(running, ShouldEqual, "<hostname>r2</hostname>")
+			So(lockedBy, ShouldEqual, 0)
+		})
+
+		Convey("hello carries base namespace", func() {
+			b, err := xml.Marshal(&hello{Capabilities: []string{capBase10}})
+			So(err, ShouldBeNil)
+			So(string(b), ShouldStartWith, `<hello xmlns="`+nsBase+`">`)
+		})
+
+		Convey("base 1.0 framing", func() {
+			d := newFakeDevice(false)
+			defer d.Close()
+			s, err := Dial(ctx, d.request())
+			So(err, ShouldBeNil)
+			defer s.Close()
+			So(s.chunked, ShouldBeFalse)
+			reply, err := s.GetConfig(ctx, "", "")
+			So(err, ShouldBeNil)
+			So(reply.Content, ShouldEqual, "<data><hostname>r1</hostname></data>")
+		})
+
+		Convey("failed operation discards changes and unlocks", func() {
+			d := newFakeDevice(true)
+			defer d.Close()
+			s, err := Dial(ctx, d.request())
+			So(err, ShouldBeNil)
+			defer s.Close()
+			res, err := s.Run(ctx, []protocol.NetconfOperation{
+				{Type: OpLock},
+				{Type: OpEditConfig, Config: "<hostname>r2</hostname>"},
+				{Type: OpEditConfig, Config: "<bad/>"},
+				{Type: OpCommit},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.(*RPCError).Tag, ShouldEqual, "invalid-value")
+			So(res[0].Status, ShouldEqual, protocol.CmdOK)
+			So(res[2].Status, ShouldEqual, protocol.CmdFailed)
+			So(res[2].Errors[0].Tag, ShouldEqual, "invalid-value")
+			So(res[3].Status, ShouldEqual, protocol.CmdSkipped)
+			running, candidate, lockedBy := d.state()
+			So(candidate, ShouldEqual, running)
+			So(lockedBy, ShouldEqual, 0)
+		})
+
+		Convey("lock denied", func() {
+			d := newFakeDevice(true)
+			defer d.Close()
+			s1, err := Dial(ctx, d.request())
+			So(err, ShouldBeNil)
+			defer s1.Close()
+			s2, err := Dial(ctx, d.request())
+			So(err, ShouldBeNil)
+			defer s2.Close()
+			_, err = s1.Lock(ctx, "")
+			So(err, ShouldBeNil)
+			res, err := s2.Run(ctx, []protocol.NetconfOperation{{Type: OpLock}})
+			So(err, ShouldNotBeNil)
+			So(res[0].Errors[0].Tag, ShouldEqual, "lock-denied")
+			_, _, lockedBy := d.state()
+			So(lockedBy, ShouldEqual, 1)
+		})
+
+		Convey("commit confirmed needs capability", func() {
+			d := newFakeDevice(true)
+			defer d.Close()
+			s, err := Dial(ctx, d.request())
+			So(err, ShouldBeNil)
+			defer s.Close()
+			_, err = s.CommitConfirmed(ctx, 60, "")
+			So(err, ShouldNotBeNil)
+
+			c := newFakeDevice(true, capConfirmedCommit+":1.1")
+			defer c.Close()
+			s, err = Dial(ctx, c.request())
+			So(err, ShouldBeNil)
+			defer s.Close()
+			_, err = s.CommitConfirmed(ctx, 60, "token")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("invalid operations", func() {
+			So(Validate([]protocol.NetconfOperation{{Type: "reboot"}}), ShouldHaveSameTypeAs, &OperationError{})
+			So(Validate([]protocol.NetconfOperation{{Type: OpEditConfig}}), ShouldNotBeNil)
+			So(Validate([]protocol.NetconfOperation{{Type: OpGet}, {Type: OpRPC, RPC: "<get-software-information/>"}}), ShouldBeNil)
+		})
+
+		Convey("cached session", func() {
+			d := newFakeDevice(true)
+			defer d.Close()
+			m := NewManager()
+			defer m.CloseAll()
+			s, err := m.Acquire(ctx, d.request())
+			So(err, ShouldBeNil)
+			m.Release(s)
+			again, err := m.Acquire(ctx, d.request())
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, s)
+
+			// leased session blocks others
+			short, stop := context.WithTimeout(ctx, 100*time.Millisecond)
+			_, err = m.Acquire(short, d.request())
+			stop()
+			So(err, ShouldResemble, context.DeadlineExceeded)
+
+			// closed session is dialed again
+			again.Close()
+			m.Release(again)
+			s, err = m.Acquire(ctx, d.request())
+			So(err, ShouldBeNil)
+			So(s.ID, ShouldEqual, "2")
+			m.Release(s)
+		})
+
+		Convey("lock left by run is released with session", func() {
+			d := newFakeDevice(true)
+			defer d.Close()
+			m := NewManager()
+			defer m.CloseAll()
+			s, err := m.Acquire(ctx, d.request())
+			So(err, ShouldBeNil)
+			_, err = s.Run(ctx, []protocol.NetconfOperation{{Type: OpLock}})
+			So(err, ShouldBeNil)
+			_, _, lockedBy := d.state()
+			So(lockedBy, ShouldEqual, 1)
+			m.Release(s)
+			_, _, lockedBy = d.state()
+			So(lockedBy, ShouldEqual, 0)
+
+			// cached session is clean for the next request
+			again, err := m.Acquire(ctx, d.request())
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, s)
+			_, err = again.Run(ctx, []protocol.NetconfOperation{{Type: OpLock}, {Type: OpUnlock}})
+			So(err, ShouldBeNil)
+			m.Release(again)
+		})
+	})
+}
@@ -0,0 +1,259 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+)
+
+// operation types of request
+const (
+	OpGet             = "get"
+	OpGetConfig       = "get-config"
+	OpEditConfig      = "edit-config"
+	OpLock            = "lock"
+	OpUnlock          = "unlock"
+	OpCommit          = "commit"
+	OpCommitConfirmed = "commit-confirmed"
+	OpDiscardChanges  = "discard-changes"
+	OpRPC             = "rpc"
+)
+
+// OperationError operation of request is invalid
+type OperationError struct {
+	Index int
+	Msg   string
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %d %s", e.Index, e.Msg)
+}
+
+func escape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+func datastore(name, def string) string {
+	if name == "" {
+		name = def
+	}
+	return "<" + escape(name) + "/>"
+}
+
+func filter(f string) string {
+	if f == "" {
+		return ""
+	}
+	return `<filter type="subtree">` + f + `</filter>`
+}
+
+// GetConfig retrieve config of source datastore
+func (s *Session) GetConfig(ctx context.Context, source, subtree string) (*Reply, error) {
+	return s.Exec(ctx, "<get-config><source>"+datastore(source, "running")+"</source>"+filter(subtree)+"</get-config>")
+}
+
+// Get retrieve running config and state data
+func (s *Session) Get(ctx context.Context, subtree string) (*Reply, error) {
+	return s.Exec(ctx, "<get>"+filter(subtree)+"</get>")
+}
+
+// EditConfig load config into target datastore
+func (s *Session) EditConfig(ctx context.Context, target, config, defaultOperation string) (*Reply, error) {
+	body := "<edit-config><target>" + datastore(target, "candidate") + "</target>"
+	if defaultOperation != "" {
+		body += "<default-operation>" + escape(defaultOperation) + "</default-operation>"
+	}
+	return s.Exec(ctx, body+"<config>"+config+"</config></edit-config>")
+}
+
+// Lock lock target datastore
+func (s *Session) Lock(ctx context.Context, target string) (*Reply, error) {
+	reply, err := s.Exec(ctx, "<lock><target>"+datastore(target, "candidate")+"</target></lock>")
+	if err == nil {
+		if s.locks == nil {
+			s.locks = make(map[string]bool)
+		}
+		s.locks[datastoreName(target)] = true
+	}
+	return reply, err
+}
+
+// Unlock unlock target datastore
+func (s *Session) Unlock(ctx context.Context, target string) (*Reply, error) {
+	reply, err := s.Exec(ctx, "<unlock><target>"+datastore(target, "candidate")+"</target></unlock>")
+	if err == nil {
+		delete(s.locks, datastoreName(target))
+	}
+	return reply, err
+}
+
+// unlockAll unlock datastores left locked by session, false if any of them is still locked
+func (s *Session) unlockAll(ctx context.Context) bool {
+	for target := range s.locks {
+		logs.Notice("unlocking", target, "left locked by session", s.ID)
+		if _, err := s.Unlock(ctx, target); err != nil {
+			logs.Notice("unlock", target, "fail,", err)
+			return false
+		}
+	}
+	return true
+}
+
+// Commit commit candidate to running, persistID confirms commit confirmed of other session
+func (s *Session) Commit(ctx context.Context, persistID string) (*Reply, error) {
+	if persistID == "" {
+		return s.Exec(ctx, "<commit/>")
+	}
+	return s.Exec(ctx, "<commit><persist-id>"+escape(persistID)+"</persist-id></commit>")
+}
+
+// CommitConfirmed commit candidate to running, device rollbacks it if not confirmed in timeout seconds
+func (s *Session) CommitConfirmed(ctx context.Context, timeout int, persist string) (*Reply, error) {
+	if !s.HasCapability(capConfirmedCommit+":1.0") && !s.HasCapability(capConfirmedCommit+":1.1") {
+		return nil, fmt.Errorf("device does not support confirmed-commit")
+	}
+	body := "<commit><confirmed/>"
+	if timeout > 0 {
+		body += "<confirm-timeout>" + strconv.Itoa(timeout) + "</confirm-timeout>"
+	}
+	if persist != "" {
+		body += "<persist>" + escape(persist) + "</persist>"
+	}
+	return s.Exec(ctx, body+"</commit>")
+}
+
+// DiscardChanges revert candidate to running
+func (s *Session) DiscardChanges(ctx context.Context) (*Reply, error) {
+	return s.Exec(ctx, "<discard-changes/>")
+}
+
+// Validate check operations before any of them is sent
+func Validate(ops []protocol.NetconfOperation) error {
+	for i, op := range ops {
+		switch op.Type {
+		case OpGet, OpGetConfig, OpLock, OpUnlock, OpCommit, OpCommitConfirmed, OpDiscardChanges:
+		case OpEditConfig:
+			if op.Config == "" {
+				return &OperationError{i, "edit-config without config"}
+			}
+		case OpRPC:
+			if op.RPC == "" {
+				return &OperationError{i, "rpc without body"}
+			}
+		default:
+			return &OperationError{i, "unknown type " + strconv.Quote(op.Type)}
+		}
+	}
+	return nil
+}
+
+func (s *Session) do(ctx context.Context, op *protocol.NetconfOperation) (*Reply, error) {
+	switch op.Type {
+	case OpGet:
+		return s.Get(ctx, op.Filter)
+	case OpGetConfig:
+		return s.GetConfig(ctx, op.Source, op.Filter)
+	case OpEditConfig:
+		return s.EditConfig(ctx, op.Target, op.Config, op.DefaultOperation)
+	case OpLock:
+		return s.Lock(ctx, op.Target)
+	case OpUnlock:
+		return s.Unlock(ctx, op.Target)
+	case OpCommit:
+		return s.Commit(ctx, op.PersistID)
+	case OpCommitConfirmed:
+		return s.CommitConfirmed(ctx, op.ConfirmTimeout, op.Persist)
+	case OpDiscardChanges:
+		return s.DiscardChanges(ctx)
+	default:
+		return s.Exec(ctx, op.RPC)
+	}
+}
+
+// Run execute operations in order, operations after the failed one are skipped
+// uncommitted changes are discarded and datastores locked by ops are unlocked on failure, so that cached session is left clean
+func (s *Session) Run(ctx context.Context, ops []protocol.NetconfOperation) ([]protocol.NetconfResult, error) {
+	if err := Validate(ops); err != nil {
+		return nil, err
+	}
+	var (
+		res    = make([]protocol.NetconfResult, len(ops))
+		edited bool
+		err    error
+	)
+	for i := range ops {
+		op := &ops[i]
+		res[i] = protocol.NetconfResult{Type: op.Type, Status: protocol.CmdSkipped}
+		if err != nil {
+			continue
+		}
+		start := time.Now()
+		var reply *Reply
+		reply, err = s.do(ctx, op)
+		res[i].Duration = int64(time.Since(start) / time.Millisecond)
+		if reply != nil {
+			res[i].Reply = strings.TrimSpace(reply.Content)
+			for _, e := range reply.Errors {
+				res[i].Errors = append(res[i].Errors, protocol.NetconfError(e))
+			}
+		}
+		if err != nil {
+			res[i].Status = protocol.CmdFailed
+			continue
+		}
+		res[i].Status = protocol.CmdOK
+		switch op.Type {
+		case OpEditConfig:
+			edited = edited || datastoreName(op.Target) == "candidate"
+		case OpCommit, OpCommitConfirmed, OpDiscardChanges:
+			edited = false
+		}
+	}
+	if err == nil {
+		return res, nil
+	}
+	if !s.IsClosed() && ctx.Err() == nil {
+		s.cleanup(ctx, edited)
+	}
+	return res, err
+}
+
+func datastoreName(name string) string {
+	if name == "" {
+		return "candidate"
+	}
+	return name
+}
+
+// cleanup discard changes and unlock datastores after failed run
+func (s *Session) cleanup(ctx context.Context, edited bool) {
+	if edited {
+		if _, err := s.DiscardChanges(ctx); err != nil {
+			logs.Notice("discard changes of failed run fail,", err)
+		}
+	}
+	s.unlockAll(ctx)
+}
@@ -0,0 +1,318 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package netconf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sky-cloud-tec/netd/cli/conn"
+	"github.com/sky-cloud-tec/netd/protocol"
+	"github.com/songtianyi/rrframework/logs"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// capabilities of base protocol versions
+	capBase10 = "urn:ietf:params:netconf:base:1.0"
+	capBase11 = "urn:ietf:params:netconf:base:1.1"
+	// capConfirmedCommit commit confirmed support, :1.1 of base 1.1 allows persist
+	capConfirmedCommit = "urn:ietf:params:netconf:capability:confirmed-commit"
+
+	nsBase = "urn:ietf:params:xml:ns:netconf:base:1.0"
+	// eom end of message of base 1.0 framing
+	eom = "]]>]]>"
+)
+
+// Session netconf session over ssh subsystem
+type Session struct {
+	key    string      // cache key
+	client *ssh.Client // ssh client of session
+	ssh    *ssh.Session
+	r      *bufio.Reader
+	w      io.WriteCloser
+
+	ID           string   // session id assigned by device
+	Capabilities []string // capabilities device announced
+
+	chunked bool            // base 1.1 chunked framing after hello
+	msgID   int             // message id of last rpc
+	locks   map[string]bool // datastores locked by session, unlocked before it is cached again
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// hello message of both peers
+type hello struct {
+	XMLName      xml.Name `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 hello"`
+	Capabilities []string `xml:"capabilities>capability"`
+	SessionID    string   `xml:"session-id,omitempty"`
+}
+
+// Dial open netconf session to device of req
+func Dial(ctx context.Context, req *protocol.CliRequest) (*Session, error) {
+	client, err := conn.DialSSH(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newSession(ctx, client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func newSession(ctx context.Context, client *ssh.Client) (*Session, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("new ssh session failed, %s", err)
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.RequestSubsystem("netconf"); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("request netconf subsystem failed, %s", err)
+	}
+	s := &Session{client: client, ssh: session, r: bufio.NewReader(r), w: w}
+	if err := s.hello(ctx); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// hello exchange capabilities, base 1.1 framing is used if both peers support it
+func (s *Session) hello(ctx context.Context) error {
+	stop := s.closeOnDone(ctx)
+	defer stop()
+	b, _ := xml.Marshal(&hello{Capabilities: []string{capBase10, capBase11}})
+	if err := s.write(append([]byte(xml.Header), b...)); err != nil {
+		return fmt.Errorf("send hello failed, %s", err)
+	}
+	msg, err := s.read()
+	if err != nil {
+		return fmt.Errorf("read hello failed, %s", err)
+	}
+	var h hello
+	if err := xml.Unmarshal(msg, &h); err != nil {
+		return fmt.Errorf("decode hello failed, %s", err)
+	}
+	s.ID, s.Capabilities = h.SessionID, h.Capabilities
+	s.chunked = s.HasCapability(capBase11)
+	return nil
+}
+
+// HasCapability tell if device announced capability, parameters of announced ones are ignored
+func (s *Session) HasCapability(c string) bool {
+	for _, v := range s.Capabilities {
+		if strings.TrimSpace(strings.SplitN(v, "?", 2)[0]) == c {
+			return true
+		}
+	}
+	return false
+}
+
+// RPCError rpc-error of rpc-reply
+type RPCError struct {
+	Type     string `xml:"error-type"`
+	Tag      string `xml:"error-tag"`
+	Severity string `xml:"error-severity"`
+	Path     string `xml:"error-path"`
+	Message  string `xml:"error-message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s %s %s, %s", e.Type, e.Severity, e.Tag, strings.TrimSpace(e.Message))
+}
+
+// Reply rpc-reply of device
+type Reply struct {
+	XMLName   xml.Name   `xml:"rpc-reply"`
+	MessageID string     `xml:"message-id,attr"`
+	Errors    []RPCError `xml:"rpc-error"`
+	Content   string     `xml:",innerxml"`
+}
+
+// Err return first rpc-error of severity error
+func (r *Reply) Err() error {
+	for i := range r.Errors {
+		if r.Errors[i].Severity != "warning" {
+			return &r.Errors[i]
+		}
+	}
+	return nil
+}
+
+// Exec send rpc of body and return its reply, reply with rpc-error of severity error is returned along with the error
+// session is closed on cancellation of ctx
+func (s *Session) Exec(ctx context.Context, body string) (*Reply, error) {
+	stop := s.closeOnDone(ctx)
+	defer stop()
+	s.msgID++
+	id := strconv.Itoa(s.msgID)
+	msg := `<rpc message-id="` + id + `" xmlns="` + nsBase + `">` + body + `</rpc>`
+	if err := s.write([]byte(msg)); err != nil {
+		return nil, s.fail(ctx, fmt.Errorf("send rpc failed, %s", err))
+	}
+	b, err := s.read()
+	if err != nil {
+		return nil, s.fail(ctx, fmt.Errorf("read rpc-reply failed, %s", err))
+	}
+	var reply Reply
+	if err := xml.Unmarshal(b, &reply); err != nil {
+		return nil, fmt.Errorf("decode rpc-reply failed, %s", err)
+	}
+	if reply.MessageID != "" && reply.MessageID != id {
+		s.Close()
+		return nil, fmt.Errorf("rpc-reply of message %s, expecting %s", reply.MessageID, id)
+	}
+	return &reply, reply.Err()
+}
+
+// fail close session after transport error, error of ctx is returned if it is done
+func (s *Session) fail(ctx context.Context, err error) error {
+	s.Close()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// closeOnDone close session if ctx is done before returned func is called
+func (s *Session) closeOnDone(ctx context.Context) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+func (s *Session) write(msg []byte) error {
+	logs.Debug("netconf >", string(msg))
+	if s.chunked {
+		if _, err := fmt.Fprintf(s.w, "\n#%d\n", len(msg)); err != nil {
+			return err
+		}
+		if _, err := s.w.Write(msg); err != nil {
+			return err
+		}
+		_, err := io.WriteString(s.w, "\n##\n")
+		return err
+	}
+	if _, err := s.w.Write(msg); err != nil {
+		return err
+	}
+	_, err := io.WriteString(s.w, eom)
+	return err
+}
+
+// read one message
+func (s *Session) read() ([]byte, error) {
+	var (
+		msg []byte
+		err error
+	)
+	if s.chunked {
+		msg, err = s.readChunks()
+	} else {
+		msg, err = s.readEOM()
+	}
+	logs.Debug("netconf <", string(msg))
+	return msg, err
+}
+
+func (s *Session) readEOM() ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return buf.Bytes(), err
+		}
+		buf.WriteByte(b)
+		if b == '>' && bytes.HasSuffix(buf.Bytes(), []byte(eom)) {
+			return bytes.TrimSpace(buf.Bytes()[:buf.Len()-len(eom)]), nil
+		}
+	}
+}
+
+// readChunks read chunked framing, \n#<size>\n<data> ... \n##\n
+func (s *Session) readChunks() ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		// leading newline, blank lines between messages are tolerated
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return buf.Bytes(), err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line == "##" {
+			return buf.Bytes(), nil
+		}
+		if !strings.HasPrefix(line, "#") {
+			return buf.Bytes(), fmt.Errorf("bad chunk header %q", line)
+		}
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil || n <= 0 {
+			return buf.Bytes(), fmt.Errorf("bad chunk header %q", line)
+		}
+		if _, err := io.CopyN(&buf, s.r, n); err != nil {
+			return buf.Bytes(), err
+		}
+	}
+}
+
+// Close close netconf session and its ssh client
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.ssh.Close()
+	return s.client.Close()
+}
+
+// IsClosed tell if session is closed
+func (s *Session) IsClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
@@ -0,0 +1,77 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package protocol
+
+import (
+	"time"
+)
+
+// HostKeyRequest accept the host key a device presents now, e.g. after device replacement
+type HostKeyRequest struct {
+	Address     string        `json:"address"`     // host:port eg. 192.168.1.101:22
+	Fingerprint string        `json:"fingerprint"` // expected SHA256 fingerprint, accept any key if empty
+	JumpHosts   []JumpHost    `json:"jumpHosts"`   // ssh jump hosts in order, device is reached through
+	Proxy       *Proxy        `json:"proxy"`       // outbound proxy, use global setting if nil
+	Timeout     time.Duration `json:"timeout"`     // req timeout setting
+	LogPrefix   string        `json:"logPrefix"`   // log prefix
+	Session     string        `json:"session"`     // session uuid
+}
+
+// HostKeyResponse ...
+type HostKeyResponse struct {
+	Retcode     int
+	Message     string
+	Fingerprint string // fingerprint of presented key
+}
+
+// ConnStatsRequest query session statistics of devices
+type ConnStatsRequest struct {
+	Address string `json:"address"` // device address, all devices if empty
+}
+
+// ConnStatsResponse ...
+type ConnStatsResponse struct {
+	Retcode int
+	Message string
+	Pools   []PoolStats
+}
+
+// PoolStats session statistics of one device
+type PoolStats struct {
+	Address  string // device address
+	Device   string // device identity
+	Username string // account sessions are logged in with
+	Context  string // virtual context
+	Min      int    // sessions kept open
+	Max      int    // max concurrent sessions
+	Idle     int    // sessions waiting for requests
+	Leased   int    // sessions serving requests or being opened
+	Waiting  int    // requests waiting for a session
+	Created  int    // sessions opened so far
+	Closed   int    // sessions closed so far
+}
+
+// EvictRequest close cached sessions of device, e.g. after credentials changed
+type EvictRequest struct {
+	Address string `json:"address"` // device address
+}
+
+// EvictResponse ...
+type EvictResponse struct {
+	Retcode int
+	Message string
+	Evicted int // sessions closed now, leased ones are closed when released
+}
@@ -19,26 +19,91 @@ import "time"
 
 // CliRequest structure
 type CliRequest struct {
-	Vendor    string        `json:"vendor"`    // device vendor
-	Type      string        `json:"type"`      // device type
-	Version   string        `json:"version"`   // device os version
-	Device    string        `json:"device"`    // device identity, uuid, hostname, etc.
-	Mode      string        `json:"mode"`      // target mode
-	Protocol  string        `json:"protocol"`  // telnet or ssh
-	Auth      Auth          `json:"auth"`      // username and password
-	Address   string        `json:"address"`   // host:port eg. 192.168.1.101:22
-	Commands  []string      `json:"commands"`  // cli commands
-	Format    string        `json:"format"`    //req format like xml,set
-	Timeout   time.Duration `json:"timeout"`   // req timeout setting
-	LogPrefix string        `json:"logPrefix"` // log prefix
-	EnablePwd string        `json:"enablePwd"` // enable password for cisco devices
-	Session   string        `json:"session"`   // session uuid
+	Vendor        string        `json:"vendor"`        // device vendor
+	Type          string        `json:"type"`          // device type
+	Version       string        `json:"version"`       // device os version
+	Device        string        `json:"device"`        // device identity, uuid, hostname, etc.
+	Mode          string        `json:"mode"`          // target mode
+	Protocol      string        `json:"protocol"`      // telnet, ssh, console or api
+	Auth          Auth          `json:"auth"`          // username and password
+	Address       string        `json:"address"`       // host:port eg. 192.168.1.101:22
+	Commands      []string      `json:"commands"`      // cli commands
+	Format        string        `json:"format"`        //req format like xml,set
+	Timeout       time.Duration `json:"timeout"`       // req timeout setting
+	LogPrefix     string        `json:"logPrefix"`     // log prefix
+	EnablePwd     string        `json:"enablePwd"`     // enable password for cisco devices
+	Session       string        `json:"session"`       // session uuid
+	HostKeyPolicy string        `json:"hostKeyPolicy"` // strict, tofu or insecure, use global setting if empty
+	JumpHosts     []JumpHost    `json:"jumpHosts"`     // ssh jump hosts in order, the first one is dialed directly
+	Proxy         *Proxy        `json:"proxy"`         // outbound proxy, use global setting if nil
+	Console       *Console      `json:"console"`       // console line settings, Address is the terminal server port
+	Pool          *Pool         `json:"pool"`          // session pool settings of device, use global setting if nil
+	Idempotent    bool          `json:"idempotent"`    // safe to run again if session is lost mid-request, login modes only
+	Context       string        `json:"context"`       // virtual context like vdom, vsys or security context, sessions are not shared across contexts
+	Answers       []Answer      `json:"answers"`       // answers of confirmation prompts, tried before operator defaults
+	RawOutput     bool          `json:"rawOutput"`     // output as read, echo, escapes and carriage returns kept
+	TLS           *TLS          `json:"tls"`           // device certificate trust of api transport, system roots if nil
+}
+
+// Answer reply sent when command output stops at a confirmation prompt
+type Answer struct {
+	Command string `json:"command"` // command the answer applies to, all commands if empty
+	Expect  string `json:"expect"`  // regexp matching last line of output, e.g. Destination filename \[.*\]\?
+	Send    string `json:"send"`    // reply, followed by linebreak unless raw
+	Raw     bool   `json:"raw"`     // send reply as is, for single key prompts
+}
+
+// TLS trust of device certificate, self-signed ones are usual on devices
+// the fingerprint pins the certificate whatever its issuer and names, otherwise the chain is verified against CA
+type TLS struct {
+	CA          string `json:"ca"`          // PEM bundle of CA certificates trusted instead of system roots
+	Fingerprint string `json:"fingerprint"` // sha256 hex of device certificate, colons allowed
+}
+
+// Pool sessions kept for one device
+type Pool struct {
+	Min               int    `json:"min"`               // sessions kept open
+	Max               int    `json:"max"`               // max concurrent sessions
+	MultiChannel      bool   `json:"multiChannel"`      // open sessions as channels of one ssh connection
+	IdleTimeout       int    `json:"idleTimeout"`       // seconds, close sessions idle for longer, 0 never
+	MaxLifetime       int    `json:"maxLifetime"`       // seconds, close sessions opened for longer, 0 never
+	Keepalive         string `json:"keepalive"`         // ssh, command or none, use global setting if empty
+	KeepaliveInterval int    `json:"keepaliveInterval"` // seconds, use global setting if 0
+}
+
+// Console device console line reached through a terminal server port
+type Console struct {
+	Raw            bool     `json:"raw"`            // raw tcp port instead of reverse telnet
+	Server         string   `json:"server"`         // terminal server management address host:port, for clearing the line
+	ServerProtocol string   `json:"serverProtocol"` // ssh or telnet, default ssh
+	ServerAuth     Auth     `json:"serverAuth"`     // terminal server credentials
+	ServerPrompt   string   `json:"serverPrompt"`   // terminal server prompt regexp, default [>#] ?$
+	ClearCommands  []string `json:"clearCommands"`  // commands run on terminal server before connecting, e.g. clear line 33
+}
+
+// Proxy outbound proxy device connections are made through
+type Proxy struct {
+	Type     string `json:"type"`     // socks5, http or none
+	Address  string `json:"address"`  // host:port
+	Username string `json:"username"` // proxy username, optional
+	Password string `json:"password"` // proxy password, optional
+}
+
+// JumpHost ssh bastion host devices are reached through
+type JumpHost struct {
+	Address string `json:"address"` // host:port eg. 10.0.0.1:22
+	Auth    Auth   `json:"auth"`    // jump host credentials
 }
 
 // Auth struct
 type Auth struct {
-	Username string `json:"Username"`
-	Password string `json:"Password"`
+	Username    string `json:"Username"`
+	Password    string `json:"Password"`
+	PrivateKey  string `json:"PrivateKey"`  // PEM encoded private key
+	Passphrase  string `json:"Passphrase"`  // private key passphrase, optional
+	Certificate string `json:"Certificate"` // OpenSSH user certificate signed for PrivateKey, authorized_keys format
+	Agent       bool   `json:"Agent"`       // use local ssh-agent through SSH_AUTH_SOCK
+	Method      string `json:"Method"`      // password, keyboard-interactive, publickey, certificate or agent, empty means all available, apikey for api transport
 }
 
 // CliResponse ...
@@ -46,5 +111,27 @@ type CliResponse struct {
 	Retcode int
 	Message string
 	Device  string
-	CmdsStd map[string]string
+	CmdsStd map[string]string // output of commands succeeded, repeated commands keep the last one
+	Results []CmdResult       // results of all commands in request order
+}
+
+// status of command result
+const (
+	CmdOK        = "ok"        // prompt back, no err pattern matched
+	CmdFailed    = "failed"    // err pattern matched
+	CmdTimeout   = "timeout"   // no prompt in time
+	CmdCancelled = "cancelled" // request cancelled while running
+	CmdLost      = "lost"      // session lost while running
+	CmdSkipped   = "skipped"   // not run, earlier command or session setup failed
+)
+
+// CmdResult result of one command
+type CmdResult struct {
+	Command    string `json:"command"`    // command sent
+	Output     string `json:"output"`     // output till prompt
+	Prompt     string `json:"prompt"`     // prompt shown after output
+	Mode       string `json:"mode"`       // mode the command ran in
+	Duration   int64  `json:"duration"`   // milliseconds
+	ErrPattern string `json:"errPattern"` // err pattern matched by output, if any
+	Status     string `json:"status"`     // ok, failed, timeout, cancelled, lost or skipped
 }
@@ -0,0 +1,65 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package protocol
+
+// NetconfRequest run netconf operations in order on one session of device
+// device, credentials, jump hosts, proxy and timeout are those of the embedded cli request, address is the netconf port, e.g. 192.168.1.1:830
+type NetconfRequest struct {
+	CliRequest
+	Operations []NetconfOperation `json:"operations"`
+}
+
+// NetconfOperation one netconf rpc
+type NetconfOperation struct {
+	Type             string `json:"type"`             // get, get-config, edit-config, lock, unlock, commit, commit-confirmed, discard-changes or rpc
+	Source           string `json:"source"`           // datastore of get-config, running by default
+	Target           string `json:"target"`           // datastore of edit-config, lock and unlock, candidate by default
+	Filter           string `json:"filter"`           // subtree filter xml of get and get-config
+	Config           string `json:"config"`           // config xml of edit-config, without config element
+	DefaultOperation string `json:"defaultOperation"` // merge, replace or none of edit-config
+	ConfirmTimeout   int    `json:"confirmTimeout"`   // seconds of commit-confirmed, device default if 0
+	Persist          string `json:"persist"`          // persist token of commit-confirmed, confirmable from other sessions
+	PersistID        string `json:"persistId"`        // confirm commit-confirmed of other session with its persist token
+	RPC              string `json:"rpc"`              // raw rpc body of type rpc, e.g. <get-software-information/>
+}
+
+// NetconfResponse ...
+type NetconfResponse struct {
+	Retcode      int
+	Message      string
+	Device       string
+	SessionID    string          // netconf session id
+	Capabilities []string        // capabilities device announced
+	Results      []NetconfResult // results of operations in request order
+}
+
+// NetconfResult result of one operation
+type NetconfResult struct {
+	Type     string         `json:"type"`     // operation type
+	Reply    string         `json:"reply"`    // rpc-reply content, data of get and get-config
+	Errors   []NetconfError `json:"errors"`   // rpc-error of reply, warnings included
+	Duration int64          `json:"duration"` // milliseconds
+	Status   string         `json:"status"`   // ok, failed or skipped, same as cli command status
+}
+
+// NetconfError rpc-error of reply
+type NetconfError struct {
+	Type     string `json:"type"`     // transport, rpc, protocol or application
+	Tag      string `json:"tag"`      // e.g. lock-denied
+	Severity string `json:"severity"` // error or warning
+	Path     string `json:"path"`     // xpath of element in error
+	Message  string `json:"message"`
+}
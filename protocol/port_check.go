@@ -21,17 +21,41 @@ import (
 
 // PortCheckRequest struct
 type PortCheckRequest struct {
-	IP        string        `json:"ip"`
-	Port      string        `json:"port"`
-	Proto     string        `json:"proto"`
-	Timeout   time.Duration `json:"timeout"`   // req timeout setting
-	LogPrefix string        `json:"logPrefix"` // log prefix
-	EnablePwd string        `json:"enablePwd"` // enable password for cisco devices
-	Session   string        `json:"session"`   // session uuid
+	IP         string        `json:"ip"`
+	Port       string        `json:"port"`
+	Proto      string        `json:"proto"`      // tcp, udp, ssh, telnet or tls, tcp by default
+	Payload    string        `json:"payload"`    // hex encoded udp probe payload, empty datagram if empty
+	ServerName string        `json:"serverName"` // tls server name for sni and verification, ip by default
+	Proxy      *Proxy        `json:"proxy"`      // outbound proxy, use global setting if nil, udp is sent directly
+	Timeout    time.Duration `json:"timeout"`    // req timeout setting
+	LogPrefix  string        `json:"logPrefix"`  // log prefix
+	EnablePwd  string        `json:"enablePwd"`  // enable password for cisco devices
+	Session    string        `json:"session"`    // session uuid
 }
 
 // PortCheckResponse struct
 type PortCheckResponse struct {
-	Retcode int
-	Message string
+	Retcode  int
+	Message  string
+	Latency  int64    // milliseconds of tcp connect, or udp round trip
+	Detail   string   // what was found, e.g. banner or certificate summary
+	Banner   string   // first line ssh or telnet service sent
+	Response string   // hex encoded udp response
+	TLS      *TLSInfo // tls handshake result
+}
+
+// TLSInfo tls handshake and certificate of service
+type TLSInfo struct {
+	Version     string    `json:"version"`     // e.g. TLS 1.2
+	CipherSuite string    `json:"cipherSuite"` // e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	Handshake   int64     `json:"handshake"`   // milliseconds
+	Subject     string    `json:"subject"`     // leaf certificate subject
+	Issuer      string    `json:"issuer"`      // leaf certificate issuer
+	DNSNames    []string  `json:"dnsNames"`    // subject alternative names
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	Expired     bool      `json:"expired"`     // not after is passed
+	DaysLeft    int       `json:"daysLeft"`    // days till not after, negative if expired
+	Verified    bool      `json:"verified"`    // chain verified against system roots for server name
+	VerifyError string    `json:"verifyError"` // why verification failed
 }
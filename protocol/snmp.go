@@ -0,0 +1,62 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package protocol
+
+import "time"
+
+// SnmpRequest snmp operation on one device
+type SnmpRequest struct {
+	Device         string        `json:"device"`         // device identity, uuid, hostname, etc.
+	Address        string        `json:"address"`        // host:port, port 161 if omitted
+	Version        string        `json:"version"`        // 2c or 3, 2c by default
+	Community      string        `json:"community"`      // v2c community
+	V3             *SnmpV3       `json:"v3"`             // v3 user based security settings
+	Operation      string        `json:"operation"`      // get, getnext, walk or bulkwalk
+	OIDs           []string      `json:"oids"`           // oids of get and getnext, root oids of walks
+	MaxRepetitions int           `json:"maxRepetitions"` // max-repetitions of bulkwalk, 50 if 0
+	Retries        *int          `json:"retries"`        // retries of each pdu, 1 if unset, 0 for none
+	Timeout        time.Duration `json:"timeout"`        // req timeout setting, split across retries of each pdu
+	LogPrefix      string        `json:"logPrefix"`      // log prefix
+	Session        string        `json:"session"`        // session uuid
+}
+
+// SnmpV3 user based security model settings
+type SnmpV3 struct {
+	Username       string `json:"username"`
+	AuthProtocol   string `json:"authProtocol"`   // md5, sha, sha224, sha256, sha384 or sha512, no auth if empty
+	AuthPassphrase string `json:"authPassphrase"` // auth passphrase
+	PrivProtocol   string `json:"privProtocol"`   // des, aes, aes192, aes256, aes192c or aes256c, no privacy if empty
+	PrivPassphrase string `json:"privPassphrase"` // privacy passphrase
+	ContextName    string `json:"contextName"`    // snmp context, optional
+}
+
+// SnmpResponse ...
+type SnmpResponse struct {
+	Retcode  int
+	Message  string
+	Device   string
+	Varbinds []Varbind // varbinds in oid order of request, walks in agent order
+}
+
+// Varbind typed variable binding
+// integers, counters, gauges and timeticks are numbers, octet strings have hex bytes and text value if printable
+// no-such-object, no-such-instance and end-of-mib-view have no value
+type Varbind struct {
+	OID   string      `json:"oid"`           // numeric oid, e.g. .1.3.6.1.2.1.1.1.0
+	Type  string      `json:"type"`          // integer, octet-string, oid, ipaddress, counter32, gauge32, timeticks, counter64, opaque, null, ...
+	Value interface{} `json:"value"`         // value of type
+	Hex   string      `json:"hex,omitempty"` // bytes of octet string and opaque, e.g. mac addresses
+}
@@ -0,0 +1,40 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package protocol
+
+// stream frame types
+const (
+	// StreamOutput output chunk of a command
+	StreamOutput = "output"
+	// StreamStatus final frame of request
+	StreamStatus = "status"
+	// StreamCancel frame caller sends to abort request
+	StreamCancel = "cancel"
+)
+
+// StreamFrame streamed cli result, frames are written one json object per line
+// the request is a CliRequest json line, send a cancel frame or drop the connection to abort it
+type StreamFrame struct {
+	Type    string            `json:"type"`              // output, status or cancel
+	Index   int               `json:"index"`             // index of command in request, output frames only
+	Command string            `json:"command,omitempty"` // command the output belongs to
+	Data    string            `json:"data,omitempty"`    // output chunk
+	Retcode int               `json:"retcode"`           // status frames only
+	Message string            `json:"message,omitempty"` // status frames only
+	Device  string            `json:"device,omitempty"`  // status frames only
+	CmdsStd map[string]string `json:"cmdsStd,omitempty"` // status frames only, same as CliResponse
+	Results []CmdResult       `json:"results,omitempty"` // status frames only, same as CliResponse
+}
@@ -0,0 +1,54 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package protocol
+
+// TransferRequest copy file between netd host and device over ssh
+// device, credentials, jump hosts, proxy and timeout are those of the embedded cli request
+type TransferRequest struct {
+	CliRequest
+	Method    string `json:"method"`    // sftp or scp, default sftp
+	Direction string `json:"direction"` // get copies from device, put copies to device
+	Remote    string `json:"remote"`    // file path on device, e.g. flash:/startup-config
+	Local     string `json:"local"`     // file path under transfer dir of netd host, content is carried in request or response if empty
+	Content   []byte `json:"content"`   // file content to put when local is empty
+	Checksum  string `json:"checksum"`  // expected sha256 hex of content, verified if not empty
+	MaxSize   int64  `json:"maxSize"`   // bytes, lower than global limit, global limit if 0
+}
+
+// TransferResponse ...
+type TransferResponse struct {
+	Retcode  int
+	Message  string
+	Device   string
+	Session  string // session of request, generated if request sets none
+	Size     int64  // bytes transferred
+	Checksum string // sha256 hex of content
+	Duration int64  // milliseconds
+	Content  []byte // file got when local is empty
+}
+
+// TransferProgressRequest query progress of a running transfer
+type TransferProgressRequest struct {
+	Session string `json:"session"` // session set in transfer request
+}
+
+// TransferProgressResponse ...
+type TransferProgressResponse struct {
+	Retcode int
+	Message string
+	Done    int64 // bytes transferred
+	Total   int64 // file size, -1 if unknown yet
+}
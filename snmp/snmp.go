@@ -0,0 +1,312 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package snmp
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/sky-cloud-tec/netd/protocol"
+)
+
+// operations of request
+const (
+	OpGet      = "get"
+	OpGetNext  = "getnext"
+	OpWalk     = "walk"
+	OpBulkWalk = "bulkwalk"
+)
+
+const (
+	// defaultPort agent port if address has none
+	defaultPort = 161
+	// defaultRetries retries of each pdu if request sets none
+	defaultRetries = 1
+)
+
+// ParamsError request parameters invalid
+type ParamsError struct {
+	Msg string
+}
+
+func (e *ParamsError) Error() string {
+	return e.Msg
+}
+
+// RequestError agent did not answer in time or is unreachable
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return "snmp request failed, " + e.Err.Error()
+}
+
+// AuthError agent rejected v3 user, security level or keys
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return "snmp auth failed, " + e.Err.Error()
+}
+
+// StatusError agent answered with error status
+type StatusError struct {
+	Status string
+	Index  int // index of varbind in error, from 1
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("agent error status %s at varbind %d", e.Status, e.Index)
+}
+
+var authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"md5":    gosnmp.MD5,
+	"sha":    gosnmp.SHA,
+	"sha224": gosnmp.SHA224,
+	"sha256": gosnmp.SHA256,
+	"sha384": gosnmp.SHA384,
+	"sha512": gosnmp.SHA512,
+}
+
+var privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"des":     gosnmp.DES,
+	"aes":     gosnmp.AES,
+	"aes192":  gosnmp.AES192,
+	"aes256":  gosnmp.AES256,
+	"aes192c": gosnmp.AES192C,
+	"aes256c": gosnmp.AES256C,
+}
+
+// newClient build client of request, timeout of request is split across retries of each pdu
+func newClient(ctx context.Context, req *protocol.SnmpRequest) (*gosnmp.GoSNMP, error) {
+	host, port := req.Address, defaultPort
+	if h, p, err := net.SplitHostPort(req.Address); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, &ParamsError{"bad port of address " + req.Address}
+		}
+		host, port = h, int(n)
+	}
+	if host == "" {
+		return nil, &ParamsError{"address missing"}
+	}
+	retries := defaultRetries
+	if req.Retries != nil {
+		retries = *req.Retries
+	}
+	if retries < 0 {
+		return nil, &ParamsError{"retries negative"}
+	}
+	c := &gosnmp.GoSNMP{
+		Context:        ctx,
+		Target:         host,
+		Port:           uint16(port),
+		Transport:      "udp",
+		Timeout:        req.Timeout / time.Duration(retries+1),
+		Retries:        retries,
+		MaxOids:        gosnmp.MaxOids,
+		MaxRepetitions: uint32(req.MaxRepetitions),
+	}
+	switch strings.ToLower(req.Version) {
+	case "", "2c", "v2c":
+		c.Version = gosnmp.Version2c
+		c.Community = req.Community
+	case "3", "v3":
+		usm, flags, err := usmParams(req.V3)
+		if err != nil {
+			return nil, err
+		}
+		c.Version = gosnmp.Version3
+		c.SecurityModel = gosnmp.UserSecurityModel
+		c.MsgFlags = flags
+		c.SecurityParameters = usm
+		c.ContextName = req.V3.ContextName
+	default:
+		return nil, &ParamsError{"snmp version " + req.Version + " not support"}
+	}
+	return c, nil
+}
+
+// usmParams map v3 settings to user based security parameters and security level
+func usmParams(v3 *protocol.SnmpV3) (*gosnmp.UsmSecurityParameters, gosnmp.SnmpV3MsgFlags, error) {
+	if v3 == nil || v3.Username == "" {
+		return nil, 0, &ParamsError{"v3 username missing"}
+	}
+	usm := &gosnmp.UsmSecurityParameters{UserName: v3.Username, AuthenticationProtocol: gosnmp.NoAuth, PrivacyProtocol: gosnmp.NoPriv}
+	flags := gosnmp.NoAuthNoPriv
+	if v3.AuthProtocol != "" {
+		p, ok := authProtocols[strings.ToLower(v3.AuthProtocol)]
+		if !ok {
+			return nil, 0, &ParamsError{"auth protocol " + v3.AuthProtocol + " not support"}
+		}
+		usm.AuthenticationProtocol, usm.AuthenticationPassphrase = p, v3.AuthPassphrase
+		flags = gosnmp.AuthNoPriv
+	}
+	if v3.PrivProtocol != "" {
+		if flags == gosnmp.NoAuthNoPriv {
+			return nil, 0, &ParamsError{"privacy without auth protocol"}
+		}
+		p, ok := privProtocols[strings.ToLower(v3.PrivProtocol)]
+		if !ok {
+			return nil, 0, &ParamsError{"privacy protocol " + v3.PrivProtocol + " not support"}
+		}
+		usm.PrivacyProtocol, usm.PrivacyPassphrase = p, v3.PrivPassphrase
+		flags = gosnmp.AuthPriv
+	}
+	return usm, flags, nil
+}
+
+// Run run operation of request, it is cancelled with ctx
+func Run(ctx context.Context, req *protocol.SnmpRequest) ([]protocol.Varbind, error) {
+	if len(req.OIDs) == 0 {
+		return nil, &ParamsError{"oids missing"}
+	}
+	c, err := newClient(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Connect(); err != nil {
+		return nil, &RequestError{Err: err}
+	}
+	defer c.Conn.Close()
+
+	var pdus []gosnmp.SnmpPDU
+	switch strings.ToLower(req.Operation) {
+	case OpGet, OpGetNext:
+		get := c.Get
+		if strings.EqualFold(req.Operation, OpGetNext) {
+			get = c.GetNext
+		}
+		// agents take at most MaxOids in one pdu
+		for i := 0; i < len(req.OIDs); i += c.MaxOids {
+			end := i + c.MaxOids
+			if end > len(req.OIDs) {
+				end = len(req.OIDs)
+			}
+			packet, err := get(req.OIDs[i:end])
+			if err != nil {
+				return nil, wrap(ctx, err)
+			}
+			if packet.Error != gosnmp.NoError {
+				return nil, &StatusError{Status: packet.Error.String(), Index: int(packet.ErrorIndex) + i}
+			}
+			pdus = append(pdus, packet.Variables...)
+		}
+	case OpWalk, OpBulkWalk:
+		walk := c.WalkAll
+		if strings.EqualFold(req.Operation, OpBulkWalk) {
+			walk = c.BulkWalkAll
+		}
+		for _, oid := range req.OIDs {
+			res, err := walk(oid)
+			if err != nil {
+				return nil, wrap(ctx, err)
+			}
+			pdus = append(pdus, res...)
+		}
+	default:
+		return nil, &ParamsError{"operation " + strconv.Quote(req.Operation) + " not support"}
+	}
+	varbinds := make([]protocol.Varbind, len(pdus))
+	for i := range pdus {
+		varbinds[i] = Varbind(&pdus[i])
+	}
+	return varbinds, nil
+}
+
+// wrap classify error of gosnmp
+func wrap(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for _, e := range []error{gosnmp.ErrUnknownUsername, gosnmp.ErrWrongDigest, gosnmp.ErrUnknownSecurityLevel, gosnmp.ErrDecryption} {
+		if errors.Is(err, e) {
+			return &AuthError{Err: err}
+		}
+	}
+	return &RequestError{Err: err}
+}
+
+// typeNames names of varbind types
+var typeNames = map[gosnmp.Asn1BER]string{
+	gosnmp.Boolean:          "boolean",
+	gosnmp.Integer:          "integer",
+	gosnmp.BitString:        "bitstring",
+	gosnmp.OctetString:      "octet-string",
+	gosnmp.Null:             "null",
+	gosnmp.ObjectIdentifier: "oid",
+	gosnmp.IPAddress:        "ipaddress",
+	gosnmp.Counter32:        "counter32",
+	gosnmp.Gauge32:          "gauge32",
+	gosnmp.TimeTicks:        "timeticks",
+	gosnmp.Opaque:           "opaque",
+	gosnmp.NsapAddress:      "nsapaddress",
+	gosnmp.Counter64:        "counter64",
+	gosnmp.Uinteger32:       "uinteger32",
+	gosnmp.OpaqueFloat:      "opaque-float",
+	gosnmp.OpaqueDouble:     "opaque-double",
+	gosnmp.NoSuchObject:     "no-such-object",
+	gosnmp.NoSuchInstance:   "no-such-instance",
+	gosnmp.EndOfMibView:     "end-of-mib-view",
+}
+
+// Varbind convert pdu to typed varbind
+func Varbind(pdu *gosnmp.SnmpPDU) protocol.Varbind {
+	v := protocol.Varbind{OID: pdu.Name, Type: typeNames[pdu.Type]}
+	if v.Type == "" {
+		v.Type = "unknown"
+	}
+	switch pdu.Type {
+	case gosnmp.Integer:
+		v.Value = gosnmp.ToBigInt(pdu.Value).Int64()
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32:
+		v.Value = gosnmp.ToBigInt(pdu.Value).Uint64()
+	case gosnmp.OctetString, gosnmp.Opaque, gosnmp.BitString, gosnmp.NsapAddress:
+		b, _ := pdu.Value.([]byte)
+		v.Hex = hex.EncodeToString(b)
+		if pdu.Type == gosnmp.OctetString && printable(b) {
+			v.Value = string(b)
+		}
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+	default:
+		v.Value = pdu.Value
+	}
+	return v
+}
+
+// printable tell if octet string is text, binary ones like mac addresses only have hex
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,255 @@
+// NetD makes network device operations easy.
+// Copyright (C) 2019  sky-cloud.net
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package snmp
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/sky-cloud-tec/netd/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeAgent snmp v2c agent stand-in serving a small mib, requests of other communities are dropped
+type fakeAgent struct {
+	pc       net.PacketConn
+	mib      []gosnmp.SnmpPDU // sorted by oid
+	requests int32
+}
+
+// oidLess compare numeric oids
+func oidLess(a, b string) bool {
+	x, y := strings.Split(strings.Trim(a, "."), "."), strings.Split(strings.Trim(b, "."), ".")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		m, _ := strconv.Atoi(x[i])
+		n, _ := strconv.Atoi(y[i])
+		if m != n {
+			return m < n
+		}
+	}
+	return len(x) < len(y)
+}
+
+func newFakeAgent() *fakeAgent {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	a := &fakeAgent{pc: pc, mib: []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: gosnmp.OctetString, Value: []byte("Cisco IOS Software, C2960")},
+		{Name: ".1.3.6.1.2.1.1.2.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.9.1.716"},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(123456)},
+		{Name: ".1.3.6.1.2.1.2.1.0", Type: gosnmp.Integer, Value: 2},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: gosnmp.OctetString, Value: []byte("GigabitEthernet0/1")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: gosnmp.OctetString, Value: []byte("GigabitEthernet0/2")},
+		{Name: ".1.3.6.1.2.1.2.2.1.6.1", Type: gosnmp.OctetString, Value: []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}},
+		{Name: ".1.3.6.1.2.1.2.2.1.6.2", Type: gosnmp.OctetString, Value: []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5f}},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: uint64(1 << 40)},
+	}}
+	sort.Slice(a.mib, func(i, j int) bool { return oidLess(a.mib[i].Name, a.mib[j].Name) })
+	go a.serve()
+	return a
+}
+
+func (a *fakeAgent) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&a.requests, 1)
+		codec := &gosnmp.GoSNMP{Version: gosnmp.Version2c, Community: "public"}
+		p, err := codec.SnmpDecodePacket(buf[:n])
+		if err != nil || p.Community != "public" {
+			continue
+		}
+		var pdus []gosnmp.SnmpPDU
+		switch p.PDUType {
+		case gosnmp.GetRequest:
+			for _, v := range p.Variables {
+				pdus = append(pdus, a.get(v.Name))
+			}
+		case gosnmp.GetNextRequest:
+			for _, v := range p.Variables {
+				pdus = append(pdus, a.next(v.Name))
+			}
+		case gosnmp.GetBulkRequest:
+			// gosnmp does not decode max-repetitions of requests
+			reps := int(p.MaxRepetitions)
+			if reps == 0 {
+				reps = 3
+			}
+			for _, v := range p.Variables {
+				name := v.Name
+				for i := 0; i < reps; i++ {
+					pdu := a.next(name)
+					pdus = append(pdus, pdu)
+					if pdu.Type == gosnmp.EndOfMibView {
+						break
+					}
+					name = pdu.Name
+				}
+			}
+		}
+		codec.SetRequestID(p.RequestID - 1)
+		out, err := codec.SnmpEncodePacket(gosnmp.GetResponse, pdus, 0, 0)
+		if err != nil {
+			panic(err)
+		}
+		a.pc.WriteTo(out, addr)
+	}
+}
+
+func (a *fakeAgent) get(oid string) gosnmp.SnmpPDU {
+	for _, v := range a.mib {
+		if v.Name == oid {
+			return v
+		}
+	}
+	return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.NoSuchObject}
+}
+
+func (a *fakeAgent) next(oid string) gosnmp.SnmpPDU {
+	for _, v := range a.mib {
+		if oidLess(oid, v.Name) {
+			return v
+		}
+	}
+	return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.EndOfMibView}
+}
+
+func (a *fakeAgent) Close() error {
+	return a.pc.Close()
+}
+
+func TestRun(t *testing.T) {
+
+	Convey("snmp v2c", t, func() {
+		agent := newFakeAgent()
+		defer agent.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		newReq := func(op string, oids ...string) *protocol.SnmpRequest {
+			return &protocol.SnmpRequest{
+				Address:   agent.pc.LocalAddr().String(),
+				Community: "public",
+				Operation: op,
+				OIDs:      oids,
+				Timeout:   2 * time.Second,
+			}
+		}
+
+		Convey("get typed varbinds", func() {
+			vbs, err := Run(ctx, newReq(OpGet, ".1.3.6.1.2.1.1.1.0", ".1.3.6.1.2.1.1.3.0", ".1.3.6.1.2.1.2.1.0", ".1.3.6.1.2.1.1.9.0"))
+			So(err, ShouldBeNil)
+			So(len(vbs), ShouldEqual, 4)
+			So(vbs[0], ShouldResemble, protocol.Varbind{
+				OID: ".1.3.6.1.2.1.1.1.0", Type: "octet-string", Value: "Cisco IOS Software, C2960", Hex: "436973636f20494f5320536f6674776172652c204332393630",
+			})
+			So(vbs[1].Type, ShouldEqual, "timeticks")
+			So(vbs[1].Value, ShouldEqual, uint64(123456))
+			So(vbs[2].Type, ShouldEqual, "integer")
+			So(vbs[2].Value, ShouldEqual, int64(2))
+			So(vbs[3].Type, ShouldEqual, "no-such-object")
+			So(vbs[3].Value, ShouldBeNil)
+		})
+
+		Convey("getnext", func() {
+			vbs, err := Run(ctx, newReq(OpGetNext, ".1.3.6.1.2.1.1.1.0"))
+			So(err, ShouldBeNil)
+			So(vbs[0].OID, ShouldEqual, ".1.3.6.1.2.1.1.2.0")
+			So(vbs[0].Type, ShouldEqual, "oid")
+			So(vbs[0].Value, ShouldEqual, ".1.3.6.1.4.1.9.1.716")
+		})
+
+		Convey("walk and bulkwalk", func() {
+			for _, op := range []string{OpWalk, OpBulkWalk} {
+				req := newReq(op, ".1.3.6.1.2.1.2.2")
+				req.MaxRepetitions = 3
+				vbs, err := Run(ctx, req)
+				So(err, ShouldBeNil)
+				So(len(vbs), ShouldEqual, 4)
+				So(vbs[0].Value, ShouldEqual, "GigabitEthernet0/1")
+				// binary octet strings have hex only
+				So(vbs[2].Value, ShouldBeNil)
+				So(vbs[2].Hex, ShouldEqual, "001a2b3c4d5e")
+			}
+			vbs, err := Run(ctx, newReq(OpBulkWalk, ".1.3.6.1.2.1.31"))
+			So(err, ShouldBeNil)
+			So(vbs[0].Type, ShouldEqual, "counter64")
+			So(vbs[0].Value, ShouldEqual, uint64(1<<40))
+		})
+
+		Convey("wrong community times out after retries", func() {
+			req := newReq(OpGet, ".1.3.6.1.2.1.1.1.0")
+			req.Community = "private"
+			retries := 2
+			req.Retries = &retries
+			req.Timeout = 300 * time.Millisecond
+			_, err := Run(ctx, req)
+			So(err, ShouldHaveSameTypeAs, &RequestError{})
+			So(atomic.LoadInt32(&agent.requests), ShouldEqual, 3)
+
+			// 0 sends each pdu once, unset retries once
+			atomic.StoreInt32(&agent.requests, 0)
+			retries = 0
+			_, err = Run(ctx, req)
+			So(err, ShouldHaveSameTypeAs, &RequestError{})
+			So(atomic.LoadInt32(&agent.requests), ShouldEqual, 1)
+			atomic.StoreInt32(&agent.requests, 0)
+			req.Retries = nil
+			_, err = Run(ctx, req)
+			So(err, ShouldHaveSameTypeAs, &RequestError{})
+			So(atomic.LoadInt32(&agent.requests), ShouldEqual, 2)
+		})
+
+		Convey("invalid params", func() {
+			negative := -1
+			for _, req := range []*protocol.SnmpRequest{
+				newReq("set", ".1.3.6.1.2.1.1.1.0"),
+				newReq(OpGet),
+				{Address: "127.0.0.1:99999", OIDs: []string{".1"}, Operation: OpGet},
+				{Address: "127.0.0.1", Version: "1", OIDs: []string{".1"}, Operation: OpGet},
+				{Address: "127.0.0.1", OIDs: []string{".1"}, Operation: OpGet, Retries: &negative},
+				{Address: "127.0.0.1", Version: "3", OIDs: []string{".1"}, Operation: OpGet, V3: &protocol.SnmpV3{Username: "u", PrivProtocol: "aes"}},
+				{Address: "127.0.0.1", Version: "3", OIDs: []string{".1"}, Operation: OpGet, V3: &protocol.SnmpV3{Username: "u", AuthProtocol: "sha1"}},
+			} {
+				_, err := Run(ctx, req)
+				So(err, ShouldHaveSameTypeAs, &ParamsError{})
+			}
+		})
+	})
+
+	Convey("snmp v3 user based security", t, func() {
+		usm, flags, err := usmParams(&protocol.SnmpV3{Username: "netd", AuthProtocol: "SHA256", AuthPassphrase: "authpass", PrivProtocol: "aes", PrivPassphrase: "privpass"})
+		So(err, ShouldBeNil)
+		So(flags, ShouldEqual, gosnmp.AuthPriv)
+		So(usm.AuthenticationProtocol, ShouldEqual, gosnmp.SHA256)
+		So(usm.PrivacyProtocol, ShouldEqual, gosnmp.AES)
+		usm, flags, err = usmParams(&protocol.SnmpV3{Username: "netd"})
+		So(err, ShouldBeNil)
+		So(flags, ShouldEqual, gosnmp.NoAuthNoPriv)
+		So(usm.AuthenticationProtocol, ShouldEqual, gosnmp.NoAuth)
+	})
+}